@@ -0,0 +1,226 @@
+package gdrive2slack
+
+import (
+	"crypto/subtle"
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	"github.com/optionfactory/gdrive2slack/google/drive"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AdminConfiguration protects /admin/api with a bearer token, with basic
+// auth credentials or with both
+type AdminConfiguration struct {
+	Token    string `json:"token"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func (self *AdminConfiguration) IsAdminConfigured() bool {
+	return self != nil && (self.Token != "" || (self.Username != "" && self.Password != ""))
+}
+
+func secretsEqual(given string, expected string) bool {
+	return expected != "" && subtle.ConstantTimeCompare([]byte(given), []byte(expected)) == 1
+}
+
+// IsAuthorized tells whether the request carries the configured credentials
+func (self *AdminConfiguration) IsAuthorized(req *http.Request) bool {
+	authorization := req.Header.Get("Authorization")
+	if strings.HasPrefix(authorization, "Bearer ") {
+		return secretsEqual(strings.TrimPrefix(authorization, "Bearer "), self.Token)
+	}
+	username, password, ok := req.BasicAuth()
+	if !ok || self.Username == "" {
+		return false
+	}
+	// both compared to take the same time
+	return secretsEqual(username, self.Username) && secretsEqual(password, self.Password)
+}
+
+// AdminSubscription is what operators see of a subscription, tokens excluded
+type AdminSubscription struct {
+	*SubscriptionSummary
+	Email             string     `json:"email"`
+	TeamId            string     `json:"teamId"`
+	SlackUserId       string     `json:"slackUserId"`
+	ChannelId         string     `json:"channelId"`
+	Mutes             []*Mute    `json:"mutes"`
+	PageToken         string     `json:"pageToken"`
+	Outbox            int        `json:"outbox"`
+	ChannelsCheckedAt *time.Time `json:"channelsCheckedAt"`
+}
+
+// NewAdminSubscription must be called by the event loop, see
+// NewSubscriptionSummary
+func NewAdminSubscription(sub *Subscription, state *UserState) *AdminSubscription {
+	admin := &AdminSubscription{
+		SubscriptionSummary: NewSubscriptionSummary(sub, state),
+		Email:               sub.GoogleUserInfo.Email,
+		TeamId:              sub.SlackUserInfo.TeamId,
+		SlackUserId:         sub.SlackUserInfo.UserId,
+		ChannelId:           sub.ChannelId,
+		Mutes:               make([]*Mute, 0, len(sub.Mutes)),
+		PageToken:           state.Gdrive.PageToken,
+		Outbox:              len(state.Outbox),
+		ChannelsCheckedAt:   copyTime(state.ChannelsCheckedAt),
+	}
+	for _, mute := range sub.Mutes {
+		admin.Mutes = append(admin.Mutes, &Mute{
+			FileId:   mute.FileId,
+			FolderId: mute.FolderId,
+			Until:    copyTime(mute.Until),
+			MutedBy:  mute.MutedBy,
+		})
+	}
+	return admin
+}
+
+type adminByTeamAndChannel []*AdminSubscription
+
+func (self adminByTeamAndChannel) Len() int      { return len(self) }
+func (self adminByTeamAndChannel) Swap(i, j int) { self[i], self[j] = self[j], self[i] }
+func (self adminByTeamAndChannel) Less(i, j int) bool {
+	return byTeamAndChannel{self[i].SubscriptionSummary, self[j].SubscriptionSummary}.Less(0, 1)
+}
+
+// requireAdmin stops the requests to the admin api lacking the configured
+// credentials, the api is disabled unless they are configured
+func requireAdmin(env *Environment) martini.Handler {
+	return func(renderer render.Render, w http.ResponseWriter, req *http.Request) {
+		admin := env.Configuration.Admin
+		if !admin.IsAdminConfigured() {
+			renderer.JSON(404, &ErrResponse{"The admin api is not configured"})
+			return
+		}
+		if !admin.IsAuthorized(req) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="gdrive2slack admin"`)
+			renderer.JSON(401, &ErrResponse{"Invalid admin credentials"})
+		}
+	}
+}
+
+// runAdminCommand runs command on the subscription with the given id in the
+// event loop, yielding the status code and the body of the response
+func runAdminCommand(env *Environment, id string, command func(subscriptions *Subscriptions, subscription *Subscription) (int, interface{})) (int, interface{}) {
+	type reply struct {
+		status int
+		body   interface{}
+	}
+	result := make(chan reply, 1)
+	env.CommandChannel <- func(subscriptions *Subscriptions) {
+		subscription, found := subscriptions.Info[id]
+		if !found {
+			result <- reply{404, &ErrResponse{"Subscription not found"}}
+			return
+		}
+		status, body := command(subscriptions, subscription)
+		result <- reply{status, body}
+	}
+	r := <-result
+	return r.status, r.body
+}
+
+// adminSave stores a subscription changed by an admin, yielding the response
+func adminSave(env *Environment, subscriptions *Subscriptions, subscription *Subscription, change string) (int, interface{}) {
+	env.Logger.Info("[%s/%s] *subscription %s: %s by admin", subscription.GoogleUserInfo.Email, subscription.SlackUserInfo.User, subscription.Id, change)
+	if err := subscriptions.Save(subscription.Id); err != nil {
+		return 500, &ErrResponse{err.Error()}
+	}
+	return 200, NewAdminSubscription(subscription, subscriptions.States[subscription.Id])
+}
+
+func handleAdminListSubscriptions(env *Environment, renderer render.Render, req *http.Request) {
+	email := req.URL.Query().Get("email")
+	result := make(chan []*AdminSubscription, 1)
+	env.CommandChannel <- func(subscriptions *Subscriptions) {
+		found := make([]*AdminSubscription, 0, len(subscriptions.Info))
+		for _, sub := range subscriptions.Info {
+			if email == "" || sub.GoogleUserInfo.Email == email {
+				found = append(found, NewAdminSubscription(sub, subscriptions.States[sub.Id]))
+			}
+		}
+		result <- found
+	}
+	found := <-result
+	sort.Sort(adminByTeamAndChannel(found))
+	renderer.JSON(200, found)
+}
+
+func handleAdminGetSubscription(env *Environment, id string) (int, interface{}) {
+	return runAdminCommand(env, id, func(subscriptions *Subscriptions, subscription *Subscription) (int, interface{}) {
+		return 200, NewAdminSubscription(subscription, subscriptions.States[id])
+	})
+}
+
+func handleAdminDeleteSubscription(env *Environment, id string) (int, interface{}) {
+	return runAdminCommand(env, id, func(subscriptions *Subscriptions, subscription *Subscription) (int, interface{}) {
+		if _, err := unsubscribe(env, subscriptions, id, subscription.GoogleUserInfo.Email, "admin"); err != nil {
+			return 500, &ErrResponse{err.Error()}
+		}
+		return 200, map[string]interface{}{"id": id}
+	})
+}
+
+func handleAdminPauseSubscription(env *Environment, id string, paused bool) (int, interface{}) {
+	return runAdminCommand(env, id, func(subscriptions *Subscriptions, subscription *Subscription) (int, interface{}) {
+		subscriptions.SetPaused(id, paused)
+		if paused {
+			return adminSave(env, subscriptions, subscription, "paused")
+		}
+		return adminSave(env, subscriptions, subscription, "resumed")
+	})
+}
+
+// handleAdminPollSubscription has the subscription served as soon as the
+// event loop is free, without waiting for the next poll. The subscription is
+// answered as it was before being served.
+func handleAdminPollSubscription(env *Environment, id string) (int, interface{}) {
+	return runAdminCommand(env, id, func(subscriptions *Subscriptions, subscription *Subscription) (int, interface{}) {
+		if subscription.Paused {
+			return 409, &ErrResponse{"Subscription is paused"}
+		}
+		env.Logger.Info("[%s/%s] polling subscription %s on admin request", subscription.GoogleUserInfo.Email, subscription.SlackUserInfo.User, id)
+		subscriptions.MarkDue(id)
+		return 202, NewAdminSubscription(subscription, subscriptions.States[id])
+	})
+}
+
+// handleAdminResetSubscription drops the drive cursor of the subscription:
+// the next poll starts from the current changes, the ones in between are
+// not notified
+func handleAdminResetSubscription(env *Environment, id string) (int, interface{}) {
+	return runAdminCommand(env, id, func(subscriptions *Subscriptions, subscription *Subscription) (int, interface{}) {
+		subscriptions.States[id].Gdrive = drive.NewState()
+		return adminSave(env, subscriptions, subscription, "cursor reset")
+	})
+}
+
+func routeAdminApi(env *Environment, m *martini.ClassicMartini) {
+	m.Group("/admin/api", func(r martini.Router) {
+		r.Get("/subscriptions", func(renderer render.Render, req *http.Request) {
+			handleAdminListSubscriptions(env, renderer, req)
+		})
+		r.Get("/subscriptions/:id", func(renderer render.Render, params martini.Params) {
+			renderer.JSON(handleAdminGetSubscription(env, params["id"]))
+		})
+		r.Delete("/subscriptions/:id", func(renderer render.Render, params martini.Params) {
+			renderer.JSON(handleAdminDeleteSubscription(env, params["id"]))
+		})
+		r.Post("/subscriptions/:id/pause", func(renderer render.Render, params martini.Params) {
+			renderer.JSON(handleAdminPauseSubscription(env, params["id"], true))
+		})
+		r.Post("/subscriptions/:id/resume", func(renderer render.Render, params martini.Params) {
+			renderer.JSON(handleAdminPauseSubscription(env, params["id"], false))
+		})
+		r.Post("/subscriptions/:id/poll", func(renderer render.Render, params martini.Params) {
+			renderer.JSON(handleAdminPollSubscription(env, params["id"]))
+		})
+		r.Post("/subscriptions/:id/reset", func(renderer render.Render, params martini.Params) {
+			renderer.JSON(handleAdminResetSubscription(env, params["id"]))
+		})
+	}, requireAdmin(env))
+}
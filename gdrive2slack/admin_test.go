@@ -0,0 +1,107 @@
+package gdrive2slack
+
+import (
+	"net/http"
+	"testing"
+)
+
+// adminEnvironment runs the commands against subs until the channel is closed
+func adminEnvironment(subs *Subscriptions) *Environment {
+	env := anEnvironment(nil)
+	env.CommandChannel = make(chan func(*Subscriptions))
+	go func() {
+		for command := range env.CommandChannel {
+			command(subs)
+		}
+	}()
+	return env
+}
+
+func adminRequest(configure func(req *http.Request)) *http.Request {
+	req, _ := http.NewRequest("GET", "/admin/api/subscriptions", nil)
+	configure(req)
+	return req
+}
+
+func TestAdminRequestsNeedTheConfiguredCredentials(t *testing.T) {
+	admin := &AdminConfiguration{Token: "s3cr3t", Username: "ops", Password: "pa55"}
+	valid := []*http.Request{
+		adminRequest(func(req *http.Request) { req.Header.Set("Authorization", "Bearer s3cr3t") }),
+		adminRequest(func(req *http.Request) { req.SetBasicAuth("ops", "pa55") }),
+	}
+	invalid := []*http.Request{
+		adminRequest(func(req *http.Request) {}),
+		adminRequest(func(req *http.Request) { req.Header.Set("Authorization", "Bearer other") }),
+		adminRequest(func(req *http.Request) { req.SetBasicAuth("ops", "other") }),
+	}
+	for _, req := range valid {
+		if !admin.IsAuthorized(req) {
+			t.Error("rejected", req.Header)
+		}
+	}
+	for _, req := range invalid {
+		if admin.IsAuthorized(req) {
+			t.Error("accepted", req.Header)
+		}
+	}
+	tokenOnly := &AdminConfiguration{Token: "s3cr3t"}
+	if tokenOnly.IsAuthorized(adminRequest(func(req *http.Request) { req.SetBasicAuth("", "") })) {
+		t.Error("empty basic auth accepted")
+	}
+	if (&AdminConfiguration{Username: "ops"}).IsAdminConfigured() {
+		t.Error("basic auth without password")
+	}
+}
+
+func TestAdminsCanPauseAndResetSubscriptions(t *testing.T) {
+	defer cleanup(t, "/tmp", "temp-subs*")
+	subs := commandSubscriptions("#general")
+	env := adminEnvironment(subs)
+	defer close(env.CommandChannel)
+	id := subs.Keys()[0]
+	subs.States[id].Gdrive.PageToken = "42"
+	if status, body := handleAdminPauseSubscription(env, id, true); status != 200 || !body.(*AdminSubscription).Paused {
+		t.Error(status, body)
+	}
+	if status, body := handleAdminResetSubscription(env, id); status != 200 || body.(*AdminSubscription).PageToken != "" {
+		t.Error(status, body)
+	}
+	if status, _ := handleAdminPollSubscription(env, id); status != 409 {
+		t.Error("paused subscription polled")
+	}
+	reloaded, _ := LoadSubscriptions(NewJsonStore("/tmp/temp-subs"))
+	if !reloaded.Info[id].Paused {
+		t.Error("pause not stored")
+	}
+}
+
+func TestAdminsCanRemoveAnySubscription(t *testing.T) {
+	defer cleanup(t, "/tmp", "temp-subs*")
+	subs := commandSubscriptions("#general")
+	env := adminEnvironment(subs)
+	defer close(env.CommandChannel)
+	id := subs.Keys()[0]
+	if status, _ := handleAdminDeleteSubscription(env, id); status != 200 || len(subs.Info) != 0 {
+		t.Error(status)
+	}
+	if status, _ := handleAdminGetSubscription(env, id); status != 404 {
+		t.Error(status)
+	}
+}
+
+func TestAdminPollsAreLeftToTheEventLoop(t *testing.T) {
+	defer cleanup(t, "/tmp", "temp-subs*")
+	subs := commandSubscriptions("#general")
+	env := adminEnvironment(subs)
+	id := subs.Keys()[0]
+	if status, _ := handleAdminPollSubscription(env, id); status != 202 {
+		t.Error(status)
+	}
+	close(env.CommandChannel)
+	if due := subs.TakeDue(); len(due) != 1 || due[0] != id {
+		t.Error(due)
+	}
+	if due := subs.TakeDue(); len(due) != 0 {
+		t.Error("served twice", due)
+	}
+}
@@ -0,0 +1,136 @@
+package gdrive2slack
+
+import (
+	"encoding/json"
+	bolt "go.etcd.io/bbolt"
+	"time"
+)
+
+var (
+	subscriptionsBucket = []byte("subscriptions")
+	statesBucket        = []byte("states")
+)
+
+// BoltStore keeps subscriptions and user states in an embedded bolt database:
+// every operation is a single transaction touching only the affected records.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+func OpenBoltStore(filename string) (*BoltStore, error) {
+	db, err := bolt.Open(filename, 0600, &bolt.Options{Timeout: time.Duration(5) * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(subscriptionsBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(statesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db}, nil
+}
+
+func (self *BoltStore) Load() (map[string]*Subscription, map[string]*UserState, error) {
+	info := make(map[string]*Subscription)
+	states := make(map[string]*UserState)
+	err := self.db.View(func(tx *bolt.Tx) error {
+		err := tx.Bucket(subscriptionsBucket).ForEach(func(k, v []byte) error {
+			subscription := new(Subscription)
+			if err := json.Unmarshal(v, subscription); err != nil {
+				return err
+			}
+			info[string(k)] = subscription
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(statesBucket).ForEach(func(k, v []byte) error {
+			state := new(UserState)
+			if err := json.Unmarshal(v, state); err != nil {
+				return err
+			}
+			states[string(k)] = state
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return info, states, nil
+}
+
+func (self *BoltStore) Upsert(key string, subscription *Subscription, state *UserState) error {
+	return self.db.Update(func(tx *bolt.Tx) error {
+		if err := put(tx.Bucket(subscriptionsBucket), key, subscription); err != nil {
+			return err
+		}
+		return put(tx.Bucket(statesBucket), key, state)
+	})
+}
+
+func (self *BoltStore) UpsertAll(info map[string]*Subscription, states map[string]*UserState) error {
+	return self.db.Update(func(tx *bolt.Tx) error {
+		for k, subscription := range info {
+			if err := put(tx.Bucket(subscriptionsBucket), k, subscription); err != nil {
+				return err
+			}
+			state, ok := states[k]
+			if !ok {
+				continue
+			}
+			if err := put(tx.Bucket(statesBucket), k, state); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (self *BoltStore) Delete(keys ...string) error {
+	return self.db.Update(func(tx *bolt.Tx) error {
+		for _, key := range keys {
+			if err := tx.Bucket(subscriptionsBucket).Delete([]byte(key)); err != nil {
+				return err
+			}
+			if err := tx.Bucket(statesBucket).Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (self *BoltStore) UpdateStates(states map[string]*UserState) error {
+	return self.db.Update(func(tx *bolt.Tx) error {
+		subscriptions := tx.Bucket(subscriptionsBucket)
+		bucket := tx.Bucket(statesBucket)
+		for k, state := range states {
+			if subscriptions.Get([]byte(k)) == nil {
+				continue
+			}
+			if err := put(bucket, k, state); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (self *BoltStore) Close() error {
+	return self.db.Close()
+}
+
+func put(bucket *bolt.Bucket, key string, v interface{}) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(key), value)
+}
@@ -0,0 +1,61 @@
+package gdrive2slack
+
+import (
+	"github.com/optionfactory/gdrive2slack/google/userinfo"
+	"github.com/optionfactory/gdrive2slack/slack"
+	"testing"
+)
+
+func TestBoltStoreReloadsUpsertedSubscriptionsAndStates(t *testing.T) {
+	defer cleanup(t, "/tmp", "temp-bolt-subs*")
+	store, err := OpenBoltStore("/tmp/temp-bolt-subs.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subs, _ := LoadSubscriptions(store)
+	subscription := &Subscription{
+		Channel:            "channel",
+		SlackAccessToken:   "slack-token",
+		GoogleRefreshToken: "g-refresh-token",
+		GoogleUserInfo:     &userinfo.UserInfo{Email: "user@example.com"},
+		SlackUserInfo:      &slack.UserInfo{},
+	}
+	if _, err := subs.Add(subscription, "a-fake-token"); err != nil {
+		t.Fatal(err)
+	}
+	subs.States[subscription.Id].Gdrive.PageToken = "42"
+	if err := subs.SaveStates(); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	store, err = OpenBoltStore("/tmp/temp-bolt-subs.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	deserialized, _ := LoadSubscriptions(store)
+	if deserialized.Info[subscription.Id].Channel != "channel" || deserialized.States[subscription.Id].Gdrive.PageToken != "42" {
+		t.Fail()
+	}
+}
+
+func TestBoltStoreForgetsDeletedSubscriptions(t *testing.T) {
+	defer cleanup(t, "/tmp", "temp-bolt-subs*")
+	store, err := OpenBoltStore("/tmp/temp-bolt-subs.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	subs, _ := LoadSubscriptions(store)
+	subscription := &Subscription{
+		GoogleUserInfo: &userinfo.UserInfo{Email: "user@example.com"},
+		SlackUserInfo:  &slack.UserInfo{},
+	}
+	subs.Add(subscription, "a-fake-token")
+	store.Delete(subscription.Id)
+	info, states, _ := store.Load()
+	if len(info) != 0 || len(states) != 0 {
+		t.Fail()
+	}
+}
@@ -0,0 +1,132 @@
+package gdrive2slack
+
+import (
+	"github.com/optionfactory/gdrive2slack/slack"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// channelsRefreshInterval is how often the channels of a subscription are
+// resolved again, following the channels renamed in the meantime
+const channelsRefreshInterval = 24 * time.Hour
+
+// channelTarget is where messages to a channel are posted: its id once
+// resolved, so that renaming the channel does not break delivery
+func channelTarget(name string, id string) string {
+	if id != "" {
+		return id
+	}
+	return name
+}
+
+type channelRef struct {
+	name *string
+	id   *string
+}
+
+// channelRefs points to the channel of the subscription and to the ones of its routes
+func (self *Subscription) channelRefs() []channelRef {
+	refs := []channelRef{{&self.Channel, &self.ChannelId}}
+	for _, route := range self.Routes {
+		refs = append(refs, channelRef{&route.Channel, &route.ChannelId})
+	}
+	return refs
+}
+
+// ChannelName yields the current name of the channel messages are posted to
+func (self *Subscription) ChannelName(target string) string {
+	for _, ref := range self.channelRefs() {
+		if *ref.id == target {
+			return *ref.name
+		}
+	}
+	return target
+}
+
+// learnChannelId records the id slack reports when posting to a channel
+// known by name only. Returns true when the subscription changed.
+func (self *Subscription) learnChannelId(target string, id string) bool {
+	if !strings.HasPrefix(target, "#") || !slack.IsConversationId(id) {
+		return false
+	}
+	learnt := false
+	for _, ref := range self.channelRefs() {
+		if *ref.id == "" && *ref.name == target {
+			*ref.id = id
+			learnt = true
+		}
+	}
+	return learnt
+}
+
+// ResolveChannels looks up the ids of the channels known by name only and
+// refreshes the names of the others. Channels that cannot be found, as well
+// as direct messages to @user, are left alone. Returns true when the
+// subscription changed.
+func ResolveChannels(client *http.Client, subscription *Subscription) (bool, slack.StatusCode, error) {
+	changed := false
+	var idsByName map[string]string
+	namesById := make(map[string]string)
+	for _, ref := range subscription.channelRefs() {
+		if *ref.id == "" && slack.IsConversationId(*ref.name) {
+			*ref.id = *ref.name
+			changed = true
+		}
+		if *ref.id != "" {
+			name, known := namesById[*ref.id]
+			if !known {
+				conversation, status, err := slack.GetConversationInfo(client, subscription.SlackAccessToken, *ref.id)
+				if status == slack.ChannelNotFound {
+					continue
+				}
+				if status != slack.Ok {
+					return changed, status, err
+				}
+				name = "#" + conversation.Name
+				namesById[*ref.id] = name
+			}
+			if *ref.name != name {
+				*ref.name = name
+				changed = true
+			}
+			continue
+		}
+		if !strings.HasPrefix(*ref.name, "#") {
+			continue
+		}
+		if idsByName == nil {
+			conversations, status, err := slack.ListConversations(client, subscription.SlackAccessToken)
+			if status != slack.Ok {
+				return changed, status, err
+			}
+			idsByName = make(map[string]string)
+			for _, conversation := range conversations {
+				idsByName["#"+conversation.Name] = conversation.Id
+			}
+		}
+		if id, found := idsByName[*ref.name]; found {
+			*ref.id = id
+			changed = true
+		}
+	}
+	return changed, slack.Ok, nil
+}
+
+// refreshChannels resolves the channels of the subscription at most once
+// every channelsRefreshInterval. Tokens granted without the channels:read
+// scope only learn the channel ids from the messages they post.
+func refreshChannels(env *Environment, subscription *Subscription, userState *UserState, now time.Time) bool {
+	if userState.ChannelsCheckedAt != nil && now.Sub(*userState.ChannelsCheckedAt) < channelsRefreshInterval {
+		return false
+	}
+	userState.ChannelsCheckedAt = &now
+	changed, status, err := ResolveChannels(env.HttpClient, subscription)
+	if status == slack.NotAuthed || status == slack.InvalidAuth || status == slack.AccountInactive || status == slack.TokenRevoked {
+		panic(err)
+	}
+	if status != slack.Ok && status != slack.MissingScope {
+		env.Logger.Warning("[%s/%s] while resolving channels: %s", subscription.GoogleUserInfo.Email, subscription.SlackUserInfo.User, err)
+	}
+	return changed
+}
@@ -0,0 +1,90 @@
+package gdrive2slack
+
+import (
+	"github.com/optionfactory/gdrive2slack/google/drive"
+	"github.com/optionfactory/gdrive2slack/slack"
+	"net/http"
+	"testing"
+	"time"
+)
+
+var (
+	slackConversations = fakeSlackResponse{200, "", `{"ok":true,"channels":[{"id":"C1","name":"general"},{"id":"C3","name":"finance"}],"response_metadata":{"next_cursor":""}}`}
+	slackRenamed       = fakeSlackResponse{200, "", `{"ok":true,"channel":{"id":"C2","name":"mockups"}}`}
+	slackMissingScope  = fakeSlackResponse{200, "", `{"ok":false,"error":"missing_scope"}`}
+)
+
+func TestChannelsAreResolvedToIdsAndRenamesFollowed(t *testing.T) {
+	fake := &fakeSlack{responses: []fakeSlackResponse{slackConversations, slackRenamed}}
+	subscription := aSubscription()
+	subscription.Routes = folderRoutes()
+	subscription.Routes[0].ChannelId = "C2"
+	subscription.Routes = append(subscription.Routes, &Route{Channel: "@jane"})
+	changed, status, err := ResolveChannels(&http.Client{Transport: fake}, subscription)
+	if !changed || status != slack.Ok {
+		t.Fatal(status, err)
+	}
+	if subscription.ChannelId != "C1" || subscription.Routes[0].Channel != "#mockups" || subscription.Routes[1].ChannelId != "C3" {
+		t.Error(subscription.ChannelId, subscription.Routes[0].Channel, subscription.Routes[1].ChannelId)
+	}
+	if subscription.Routes[2].ChannelId != "" || subscription.Routes[3].ChannelId != "" || len(fake.posted) != 2 {
+		t.Error(fake.posted)
+	}
+}
+
+func TestChangesAreRoutedToResolvedChannels(t *testing.T) {
+	subscription := aSubscription()
+	subscription.Routes = folderRoutes()
+	subscription.ChannelId = "C1"
+	subscription.Routes[0].ChannelId = "C2"
+	changes := []drive.ChangeItem{aChange("", "mockups", drive.Modified), aChange("", "other", drive.Modified)}
+	channels, _ := RouteChanges(subscription, changes, routingFolders)
+	if len(channels) != 2 || channels[0] != "C2" || channels[1] != "C1" {
+		t.Error(channels)
+	}
+	if subscription.ChannelName("C2") != "#design" || subscription.ChannelName("#finance") != "#finance" {
+		t.Fail()
+	}
+}
+
+func TestChannelIdsAreLearntFromPostedMessages(t *testing.T) {
+	fake := &fakeSlack{responses: []fakeSlackResponse{slackOk}}
+	subscription := aSubscription()
+	subscription.Routes = folderRoutes()
+	if !deliverOutbox(anEnvironment(fake), subscription, queued("#general")) {
+		t.Fail()
+	}
+	if subscription.ChannelId != "C0123" || subscription.Routes[0].ChannelId != "" {
+		t.Error(subscription.ChannelId)
+	}
+	if deliverOutbox(anEnvironment(fake), subscription, queued("C0123")) {
+		t.Error("nothing to learn")
+	}
+}
+
+func TestChannelsAreRefreshedOnceADayEvenWithoutTheScope(t *testing.T) {
+	fake := &fakeSlack{responses: []fakeSlackResponse{slackMissingScope}}
+	env := anEnvironment(fake)
+	state := &UserState{}
+	now := time.Now()
+	subscription := aSubscription()
+	subscription.Routes = folderRoutes()
+	refreshChannels(env, subscription, state, now)
+	refreshChannels(env, subscription, state, now.Add(time.Hour))
+	if len(fake.posted) != 1 || !state.ChannelsCheckedAt.Equal(now) {
+		t.Error(fake.posted)
+	}
+	refreshChannels(env, subscription, state, now.Add(channelsRefreshInterval))
+	if len(fake.posted) != 2 {
+		t.Error(fake.posted)
+	}
+}
+
+func TestEscapedChannelsComeWithTheirId(t *testing.T) {
+	if name, id, valid := channelOf("<#C123|design>"); !valid || name != "#design" || id != "C123" {
+		t.Error(name, id)
+	}
+	if name, id, valid := channelOf("#design"); !valid || name != "#design" || id != "" {
+		t.Error(name, id)
+	}
+}
@@ -0,0 +1,248 @@
+package gdrive2slack
+
+import (
+	"fmt"
+	"github.com/martini-contrib/render"
+	"github.com/optionfactory/gdrive2slack/slack"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// slack gives up on slash commands not answered within 3 seconds: slower
+// replies are posted to the response url of the command
+const slashCommandWait = 2 * time.Second
+
+const slashCommandUsage = "Usage: `/gdrive status`, `/gdrive pause`, `/gdrive resume`, `/gdrive channel #channel`, `/gdrive folders add <folder id or link>`, `/gdrive folders remove <folder id or link>`, `/gdrive unmute`, `/gdrive unsubscribe`"
+
+// SlashCommand is what slack posts when a user runs /gdrive
+type SlashCommand struct {
+	TeamId      string
+	UserId      string
+	ChannelId   string
+	ChannelName string
+	Text        string
+	ResponseUrl string
+}
+
+type slashCommandResponse struct {
+	ResponseType string `json:"response_type"`
+	Text         string `json:"text"`
+}
+
+type byChannel []*Subscription
+
+func (self byChannel) Len() int           { return len(self) }
+func (self byChannel) Swap(i, j int)      { self[i], self[j] = self[j], self[i] }
+func (self byChannel) Less(i, j int) bool { return self[i].Channel < self[j].Channel }
+
+var (
+	folderLinkPattern     = regexp.MustCompile(`/folders/([-\w]+)`)
+	escapedChannelPattern = regexp.MustCompile(`^<#(\w+)\|([^>]+)>$`)
+)
+
+// folderIdOf accepts folder ids as well as links to folders, possibly
+// escaped by slack as <link|label>
+func folderIdOf(arg string) string {
+	arg = strings.TrimSuffix(strings.TrimPrefix(arg, "<"), ">")
+	arg = strings.SplitN(arg, "|", 2)[0]
+	if match := folderLinkPattern.FindStringSubmatch(arg); match != nil {
+		return match[1]
+	}
+	return arg
+}
+
+// channelOf accepts #channel and @user as well as channels escaped by slack,
+// which come with their id
+func channelOf(arg string) (string, string, bool) {
+	if match := escapedChannelPattern.FindStringSubmatch(arg); match != nil {
+		return "#" + match[2], match[1], true
+	}
+	if len(arg) > 1 && (arg[0] == '#' || arg[0] == '@') {
+		return arg, "", true
+	}
+	return "", "", false
+}
+
+func describeSubscription(subscription *Subscription, userState *UserState) string {
+	folders := "all folders"
+	if len(subscription.GoogleInterestingFolderIds) != 0 {
+		folders = "folders " + strings.Join(subscription.GoogleInterestingFolderIds, ", ")
+	}
+	status := "active"
+	if subscription.Paused {
+		status = "paused"
+	} else if userState != nil && userState.FailingSince != nil {
+		status = fmt.Sprintf("failing since %s", userState.FailingSince.UTC().Format("2006-01-02 15:04 UTC"))
+	}
+	if len(subscription.Mutes) != 0 {
+		status = fmt.Sprintf("%s, %d mutes", status, len(subscription.Mutes))
+	}
+	return fmt.Sprintf("%s: %s of %s, %s", subscription.Channel, folders, subscription.GoogleUserInfo.Email, status)
+}
+
+// selectSubscription picks the subscription a command is about: the only one
+// of the user or the one notifying the channel the command was run in
+func selectSubscription(owned []*Subscription, command *SlashCommand) (*Subscription, bool) {
+	if len(owned) == 1 {
+		return owned[0], true
+	}
+	for _, subscription := range owned {
+		if subscription.Channel == "#"+command.ChannelName || (subscription.ChannelId != "" && subscription.ChannelId == command.ChannelId) {
+			return subscription, true
+		}
+	}
+	return nil, false
+}
+
+// RunSlashCommand changes the subscriptions of the slack user as asked, it
+// must be run by the event loop. The result is the reply to the user.
+func RunSlashCommand(env *Environment, subscriptions *Subscriptions, command *SlashCommand) string {
+	args := strings.Fields(command.Text)
+	if len(args) == 0 || args[0] == "help" {
+		return slashCommandUsage
+	}
+	owned := subscriptions.FindBySlackUser(command.TeamId, command.UserId)
+	if len(owned) == 0 {
+		return fmt.Sprintf("You have no subscription in this team, <%s|create one>.", env.Configuration.Google.RedirectUri)
+	}
+	sort.Sort(byChannel(owned))
+	if args[0] == "status" {
+		lines := make([]string, 0, len(owned))
+		for _, subscription := range owned {
+			lines = append(lines, "• "+describeSubscription(subscription, subscriptions.States[subscription.Id]))
+		}
+		return strings.Join(lines, "\n")
+	}
+	subscription, found := selectSubscription(owned, command)
+	if !found {
+		return "You have several subscriptions: run the command in the channel of the one to change."
+	}
+	email := subscription.GoogleUserInfo.Email
+	slackUser := subscription.SlackUserInfo.User
+	var reply string
+	switch {
+	case args[0] == "pause" && len(args) == 1:
+		subscriptions.SetPaused(subscription.Id, true)
+		reply = fmt.Sprintf("Notifications to %s are paused.", subscription.Channel)
+	case args[0] == "resume" && len(args) == 1:
+		subscriptions.SetPaused(subscription.Id, false)
+		reply = fmt.Sprintf("Notifications to %s are resumed.", subscription.Channel)
+	case args[0] == "channel" && len(args) == 2:
+		channel, channelId, valid := channelOf(args[1])
+		if !valid {
+			return "Invalid channel, use `#channel` or `@user`."
+		}
+		subscription.Channel = channel
+		subscription.ChannelId = channelId
+		// resolved by the next poll
+		subscriptions.States[subscription.Id].ChannelsCheckedAt = nil
+		reply = fmt.Sprintf("Changes will be notified to %s.", channel)
+	case args[0] == "folders" && len(args) == 3 && args[1] == "add":
+		folderId := folderIdOf(args[2])
+		for _, id := range subscription.GoogleInterestingFolderIds {
+			if id == folderId {
+				return fmt.Sprintf("Folder %s is already watched.", folderId)
+			}
+		}
+		subscription.GoogleInterestingFolderIds = append(subscription.GoogleInterestingFolderIds, folderId)
+		reply = fmt.Sprintf("Folder %s is now watched.", folderId)
+	case args[0] == "folders" && len(args) == 3 && args[1] == "remove":
+		folderId := folderIdOf(args[2])
+		kept := make([]string, 0, len(subscription.GoogleInterestingFolderIds))
+		for _, id := range subscription.GoogleInterestingFolderIds {
+			if id != folderId {
+				kept = append(kept, id)
+			}
+		}
+		if len(kept) == len(subscription.GoogleInterestingFolderIds) {
+			return fmt.Sprintf("Folder %s is not watched.", folderId)
+		}
+		subscription.GoogleInterestingFolderIds = kept
+		reply = fmt.Sprintf("Folder %s is not watched anymore.", folderId)
+	case args[0] == "unmute" && len(args) == 1:
+		subscription.Mutes = nil
+		reply = fmt.Sprintf("Muted files and folders are notified to %s again.", subscription.Channel)
+	case args[0] == "unsubscribe" && len(args) == 1:
+		if _, err := unsubscribe(env, subscriptions, subscription.Id, email, "user"); err != nil {
+			env.Logger.Warning("[%s/%s] cannot remove subscription: %s", email, slackUser, err)
+		}
+		return fmt.Sprintf("Changes will not be notified to %s anymore.", subscription.Channel)
+	default:
+		return slashCommandUsage
+	}
+	env.Logger.Info("[%s/%s] *subscription %s: /gdrive %s", email, slackUser, subscription.Id, command.Text)
+	if err := subscriptions.Save(subscription.Id); err != nil {
+		env.Logger.Warning("[%s/%s] cannot store subscription: %s", email, slackUser, err)
+	}
+	return reply
+}
+
+// handleSlashCommand serves /gdrive, it is disabled unless the slack signing
+// secret is configured
+func handleSlashCommand(env *Environment, renderer render.Render, req *http.Request) {
+	signingSecret := env.Configuration.Slack.SigningSecret
+	if signingSecret == "" {
+		renderer.JSON(404, &ErrResponse{"Slash commands are not configured"})
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, 1<<20))
+	if err != nil {
+		renderer.JSON(400, &ErrResponse{err.Error()})
+		return
+	}
+	if !slack.VerifyRequest(signingSecret, req.Header, body, time.Now()) {
+		renderer.JSON(401, &ErrResponse{"Invalid request signature"})
+		return
+	}
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		renderer.JSON(400, &ErrResponse{err.Error()})
+		return
+	}
+	command := &SlashCommand{
+		TeamId:      values.Get("team_id"),
+		UserId:      values.Get("user_id"),
+		ChannelId:   values.Get("channel_id"),
+		ChannelName: values.Get("channel_name"),
+		Text:        values.Get("text"),
+		ResponseUrl: values.Get("response_url"),
+	}
+	renderer.JSON(200, replySlashCommand(env, command, slashCommandWait))
+}
+
+// replySlashCommand runs command in the event loop, answering with its
+// outcome when it takes less than wait. Otherwise the outcome is posted to
+// the response url of the command once known.
+func replySlashCommand(env *Environment, command *SlashCommand, wait time.Duration) *slashCommandResponse {
+	result := make(chan string, 1)
+	accepted := env.Requests.Go(func() {
+		env.CommandChannel <- func(subscriptions *Subscriptions) {
+			result <- RunSlashCommand(env, subscriptions, command)
+		}
+	})
+	if !accepted {
+		return &slashCommandResponse{"ephemeral", "gdrive2slack is restarting, please retry in a minute."}
+	}
+	select {
+	case text := <-result:
+		return &slashCommandResponse{"ephemeral", text}
+	case <-time.After(wait):
+	}
+	respond := func() {
+		text := <-result
+		if _, err := slack.Respond(env.HttpClient, command.ResponseUrl, text); err != nil {
+			env.Logger.Warning("cannot respond to slash command: %s", err)
+		}
+	}
+	if !env.Requests.Go(respond) {
+		// the command is running already
+		go respond()
+	}
+	return &slashCommandResponse{"ephemeral", "Working on it, the outcome will follow shortly."}
+}
@@ -0,0 +1,183 @@
+package gdrive2slack
+
+import (
+	"errors"
+	"github.com/optionfactory/gdrive2slack/google/userinfo"
+	"github.com/optionfactory/gdrive2slack/slack"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func commandSubscriptions(channels ...string) *Subscriptions {
+	subs, _ := LoadSubscriptions(NewJsonStore("/tmp/temp-subs"))
+	for _, channel := range channels {
+		subs.Add(&Subscription{
+			Channel:                    channel,
+			GoogleUserInfo:             &userinfo.UserInfo{Email: "user@example.com"},
+			SlackUserInfo:              &slack.UserInfo{TeamId: "T1", UserId: "U1", User: "jane"},
+			GoogleInterestingFolderIds: []string{},
+		}, "a-fake-token")
+	}
+	return subs
+}
+
+func runCommand(subs *Subscriptions, channelName string, text string) string {
+	return RunSlashCommand(anEnvironment(nil), subs, &SlashCommand{TeamId: "T1", UserId: "U1", ChannelName: channelName, Text: text})
+}
+
+func TestPausedSubscriptionsAreNotServed(t *testing.T) {
+	defer cleanup(t, "/tmp", "temp-subs*")
+	subs := commandSubscriptions("#general")
+	runCommand(subs, "random", "pause")
+	if len(subs.ActiveKeys()) != 0 || !strings.Contains(runCommand(subs, "random", "status"), "paused") {
+		t.Fail()
+	}
+	runCommand(subs, "random", "resume")
+	if len(subs.ActiveKeys()) != 1 {
+		t.Fail()
+	}
+}
+
+func TestChannelAndFoldersCanBeChangedAndAreStored(t *testing.T) {
+	defer cleanup(t, "/tmp", "temp-subs*")
+	subs := commandSubscriptions("#general")
+	runCommand(subs, "general", "channel <#C123|design>")
+	runCommand(subs, "design", "folders add <https://drive.google.com/drive/folders/0B1abc-_X|mockups>")
+	runCommand(subs, "design", "folders add 0B2def")
+	runCommand(subs, "design", "folders remove 0B1abc-_X")
+	reloaded, _ := LoadSubscriptions(NewJsonStore("/tmp/temp-subs"))
+	for _, sub := range reloaded.Info {
+		if sub.Channel != "#design" || strings.Join(sub.GoogleInterestingFolderIds, ",") != "0B2def" {
+			t.Error(sub.Channel, sub.GoogleInterestingFolderIds)
+		}
+	}
+}
+
+func TestCommandsPickTheSubscriptionOfTheCurrentChannel(t *testing.T) {
+	defer cleanup(t, "/tmp", "temp-subs*")
+	subs := commandSubscriptions("#design", "#finance")
+	if reply := runCommand(subs, "general", "pause"); !strings.Contains(reply, "several subscriptions") {
+		t.Error(reply)
+	}
+	runCommand(subs, "finance", "unsubscribe")
+	if len(subs.Info) != 1 || subs.FindBySlackUser("T1", "U1")[0].Channel != "#design" {
+		t.Fail()
+	}
+}
+
+func TestCommandsOnlyConcernTheSlackUser(t *testing.T) {
+	defer cleanup(t, "/tmp", "temp-subs*")
+	subs := commandSubscriptions("#general")
+	reply := RunSlashCommand(anEnvironment(nil), subs, &SlashCommand{TeamId: "T1", UserId: "U2", Text: "unsubscribe"})
+	if len(subs.Info) != 1 || !strings.Contains(reply, "no subscription") {
+		t.Error(reply)
+	}
+}
+
+func TestSlackRequestsAreVerified(t *testing.T) {
+	body := []byte("team_id=T1&text=status")
+	now := time.Now()
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	header := http.Header{}
+	header.Set("X-Slack-Request-Timestamp", timestamp)
+	header.Set("X-Slack-Signature", slack.RequestSignature("secret", timestamp, body))
+	if !slack.VerifyRequest("secret", header, body, now) {
+		t.Error("valid signature rejected")
+	}
+	if slack.VerifyRequest("other", header, body, now) || slack.VerifyRequest("secret", header, []byte("team_id=T2&text=status"), now) {
+		t.Error("invalid signature accepted")
+	}
+	if slack.VerifyRequest("secret", header, body, now.Add(10*time.Minute)) {
+		t.Error("replayed request accepted")
+	}
+}
+
+// blockingTransport holds every request until released, then fails it
+type blockingTransport struct {
+	started chan bool
+	release chan bool
+}
+
+func (self *blockingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	select {
+	case self.started <- true:
+	default:
+	}
+	<-self.release
+	return nil, errors.New("unreachable")
+}
+
+func TestCommandsRunBetweenPolledSubscriptions(t *testing.T) {
+	defer cleanup(t, "/tmp", "temp-subs*")
+	subs := commandSubscriptions("#general", "#design")
+	keys := subs.Keys()
+	transport := &blockingTransport{started: make(chan bool), release: make(chan bool)}
+	env := anEnvironment(nil)
+	env.Configuration.Workers = 1
+	env.HttpClient = &http.Client{Transport: transport}
+	env.CommandChannel = make(chan func(*Subscriptions))
+	result := make(chan int, 1)
+	go func() {
+		served, _, _ := serve(env, subs, keys)
+		result <- served
+	}()
+	<-transport.started
+	env.CommandChannel <- func(subscriptions *Subscriptions) {
+		subscriptions.Remove(keys[1], "user@example.com")
+	}
+	close(transport.release)
+	if served := <-result; served != 1 || subs.Contains(keys[1]) {
+		t.Error(served)
+	}
+}
+
+// respondingSlack records the bodies posted to response urls
+type respondingSlack chan string
+
+func (self respondingSlack) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, _ := ioutil.ReadAll(req.Body)
+	self <- string(body)
+	return &http.Response{StatusCode: 200, Header: make(http.Header), Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+}
+
+func TestSlashCommandsAreAnsweredRightAway(t *testing.T) {
+	defer cleanup(t, "/tmp", "temp-subs*")
+	subs := commandSubscriptions("#general")
+	env := anEnvironment(nil)
+	env.CommandChannel = make(chan func(*Subscriptions))
+	go func() {
+		command := <-env.CommandChannel
+		command(subs)
+	}()
+	reply := replySlashCommand(env, &SlashCommand{TeamId: "T1", UserId: "U1", ChannelName: "general", Text: "status"}, time.Second)
+	if reply.Text != runCommand(subs, "general", "status") {
+		t.Error(reply.Text)
+	}
+}
+
+func TestSlowSlashCommandsAreAnsweredThroughTheResponseUrl(t *testing.T) {
+	defer cleanup(t, "/tmp", "temp-subs*")
+	subs := commandSubscriptions("#general")
+	responses := make(respondingSlack, 1)
+	env := anEnvironment(nil)
+	env.HttpClient = &http.Client{Transport: responses}
+	env.CommandChannel = make(chan func(*Subscriptions))
+	reply := replySlashCommand(env, &SlashCommand{TeamId: "T1", UserId: "U1", ChannelName: "general", Text: "status", ResponseUrl: "https://hooks.slack.com/commands/1"}, time.Millisecond)
+	if !strings.Contains(reply.Text, "Working on it") {
+		t.Error(reply.Text)
+	}
+	command := <-env.CommandChannel
+	command(subs)
+	select {
+	case body := <-responses:
+		if !strings.Contains(body, `"response_type":"ephemeral"`) || !strings.Contains(body, "general") {
+			t.Error(body)
+		}
+	case <-time.After(time.Second):
+		t.Error("no response posted")
+	}
+}
@@ -13,13 +13,33 @@ import (
 )
 
 type Configuration struct {
-	BindAddress      string                     `json:"bindAddress"`
-	Workers          int                        `json:"workers"`
-	Interval         int                        `json:"interval"`
-	GoogleTrackingId string                     `json:"googleTrackingId"`
-	Google           *google.OauthConfiguration `json:"google"`
-	Slack            *slack.OauthConfiguration  `json:"slack"`
-	Mailchimp        *mailchimp.Configuration   `json:"mailchimp"`
+	BindAddress       string                     `json:"bindAddress"`
+	Workers           int                        `json:"workers"`
+	Interval          int                        `json:"interval"`
+	MaxChangesPerPoll int                        `json:"maxChangesPerPoll"`
+	GoogleTrackingId  string                     `json:"googleTrackingId"`
+	Google            *google.OauthConfiguration `json:"google"`
+	Slack             *slack.OauthConfiguration  `json:"slack"`
+	Mailchimp         *mailchimp.Configuration   `json:"mailchimp"`
+	Store             *StoreConfiguration        `json:"store"`
+	Push              *PushConfiguration         `json:"push"`
+	SessionSecret     string                     `json:"sessionSecret"`
+	Admin             *AdminConfiguration        `json:"admin"`
+	// ReadyLoops is how many intervals a loop can take before /readyz fails
+	ReadyLoops int `json:"readyLoops"`
+	// ShutdownTimeout is how many seconds a shutdown waits for the pending work
+	ShutdownTimeout int `json:"shutdownTimeout"`
+}
+
+// Address is the public url of the drive notifications endpoint, channels
+// last Ttl seconds and are renewed while polling.
+type PushConfiguration struct {
+	Address string `json:"address"`
+	Ttl     int    `json:"ttl"`
+}
+
+func (self *PushConfiguration) IsPushConfigured() bool {
+	return self != nil && self.Address != ""
 }
 
 func LoadConfiguration(filename string) (*Configuration, error) {
@@ -33,16 +53,39 @@ func LoadConfiguration(filename string) (*Configuration, error) {
 	if err != nil {
 		return nil, err
 	}
+	if self.MaxChangesPerPoll == 0 {
+		self.MaxChangesPerPoll = 100
+	}
+	if self.ReadyLoops == 0 {
+		self.ReadyLoops = 3
+	}
+	if self.ShutdownTimeout == 0 {
+		self.ShutdownTimeout = 30
+	}
+	if self.Push != nil && self.Push.Ttl == 0 {
+		self.Push.Ttl = 86400
+	}
 	return self, nil
 }
 
 type Environment struct {
-	Version         string
-	Configuration   *Configuration
-	Logger          *Logger
-	HttpClient      *http.Client
-	RegisterChannel chan *SubscriptionAndAccessToken
-	SignalsChannel  chan os.Signal
+	Version             string
+	Configuration       *Configuration
+	Logger              *Logger
+	HttpClient          *http.Client
+	RegisterChannel     chan *SubscriptionAndAccessToken
+	NotificationChannel chan *DriveNotification
+	CommandChannel      chan func(*Subscriptions)
+	SignalsChannel      chan os.Signal
+	// Stopping is closed on shutdown, Stopped once the event loop is done
+	Stopping      chan struct{}
+	Stopped       chan struct{}
+	Requests      *RequestGate
+	SessionKey    []byte
+	SlackUsers    *SlackUserCache
+	SlackThrottle *SlackThrottle
+	Metrics       *Metrics
+	Health        *Health
 }
 
 func NewEnvironment(version string, conf *Configuration, logger *Logger) *Environment {
@@ -53,9 +96,26 @@ func NewEnvironment(version string, conf *Configuration, logger *Logger) *Enviro
 		HttpClient: &http.Client{
 			Timeout: time.Duration(15) * time.Second,
 		},
-		RegisterChannel: make(chan *SubscriptionAndAccessToken, 50),
-		SignalsChannel:  make(chan os.Signal, 1),
+		RegisterChannel:     make(chan *SubscriptionAndAccessToken, 50),
+		NotificationChannel: make(chan *DriveNotification, 100),
+		CommandChannel:      make(chan func(*Subscriptions)),
+		SignalsChannel:      make(chan os.Signal, 1),
+		Stopping:            make(chan struct{}),
+		Stopped:             make(chan struct{}),
+		Requests:            NewRequestGate(),
+		SessionKey:          []byte(conf.SessionSecret),
+		SlackThrottle:       NewSlackThrottle(),
+		Health:              NewHealth(time.Now()),
+	}
+	e.Metrics = NewMetrics(e)
+	e.SlackUsers = NewSlackUserCache(slackUserCacheTtl, func(accessToken string, email string) (*slack.User, slack.StatusCode, error) {
+		return slack.LookupUserByEmail(e.HttpClient, accessToken, email)
+	})
+	if conf.SessionSecret == "" {
+		// sessions will not survive a restart
+		e.SessionKey = []byte(randomToken())
 	}
 	signal.Notify(e.SignalsChannel, syscall.SIGINT, syscall.Signal(0xf))
+	go handleSignals(e)
 	return e
 }
@@ -0,0 +1,260 @@
+package gdrive2slack
+
+import (
+	"fmt"
+	"github.com/optionfactory/gdrive2slack/google/drive"
+	"github.com/optionfactory/gdrive2slack/slack"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	DailyDigest  = "daily"
+	WeeklyDigest = "weekly"
+)
+
+const (
+	// bounds the changes kept in the user state while waiting for a digest
+	maxPendingDigestChanges = 1000
+	// changes listed one by one after the grouped summary
+	maxDigestAttachments = 20
+	// entries of each group of the summary, the others are only counted
+	maxDigestSummaryEntries = 10
+	// slack rejects longer section fields with invalid_blocks
+	maxDigestSummaryFieldLength = 2000
+)
+
+// DigestConfiguration replaces per-poll messages with a summary posted every
+// day (or every week on Weekday) at Hour, local time in TimeZone.
+type DigestConfiguration struct {
+	Frequency string       `json:"frequency"`
+	Hour      int          `json:"hour"`
+	Weekday   time.Weekday `json:"weekday"`
+	TimeZone  string       `json:"time_zone"`
+}
+
+func (self *DigestConfiguration) IsDigestConfigured() bool {
+	return self != nil && self.Frequency != ""
+}
+
+func (self *DigestConfiguration) Validate() error {
+	if self.Frequency != DailyDigest && self.Frequency != WeeklyDigest {
+		return fmt.Errorf("invalid digest frequency: '%s'", self.Frequency)
+	}
+	if self.Hour < 0 || self.Hour > 23 {
+		return fmt.Errorf("invalid digest hour: %d", self.Hour)
+	}
+	if self.Weekday < time.Sunday || self.Weekday > time.Saturday {
+		return fmt.Errorf("invalid digest weekday: %d", self.Weekday)
+	}
+	if _, err := time.LoadLocation(self.TimeZone); err != nil {
+		return fmt.Errorf("invalid digest time zone: '%s'", self.TimeZone)
+	}
+	return nil
+}
+
+func (self *DigestConfiguration) location() *time.Location {
+	location, err := time.LoadLocation(self.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+// NextDelivery is the first scheduled delivery strictly after the given time
+func (self *DigestConfiguration) NextDelivery(after time.Time) time.Time {
+	local := after.In(self.location())
+	next := time.Date(local.Year(), local.Month(), local.Day(), self.Hour, 0, 0, 0, local.Location())
+	days := 1
+	if self.Frequency == WeeklyDigest {
+		days = 7
+		next = next.AddDate(0, 0, (int(self.Weekday)-int(next.Weekday())+7)%7)
+	}
+	if !next.After(local) {
+		next = next.AddDate(0, 0, days)
+	}
+	return next
+}
+
+type DigestEntry struct {
+	Change drive.ChangeItem `json:"change"`
+	Action drive.Action     `json:"action"`
+	Type   drive.ItemType   `json:"type"`
+}
+
+// DigestState holds the changes detected since the last delivery
+type DigestState struct {
+	Pending        []*DigestEntry `json:"pending"`
+	Omitted        int            `json:"omitted"`
+	LastDeliveryAt time.Time      `json:"last_delivery_at"`
+}
+
+func NewDigestState(now time.Time) *DigestState {
+	return &DigestState{
+		Pending:        make([]*DigestEntry, 0),
+		LastDeliveryAt: now,
+	}
+}
+
+func (self *DigestState) Add(changes []drive.ChangeItem, omitted int) {
+	self.Omitted += omitted
+	for _, change := range changes {
+		if len(self.Pending) >= maxPendingDigestChanges {
+			self.Omitted++
+			continue
+		}
+		self.Pending = append(self.Pending, &DigestEntry{change, change.LastAction, change.Type})
+	}
+}
+
+func (self *DigestState) Changes() []drive.ChangeItem {
+	changes := make([]drive.ChangeItem, 0, len(self.Pending))
+	for _, entry := range self.Pending {
+		change := entry.Change
+		change.LastAction = entry.Action
+		change.Type = entry.Type
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// flushDigest moves the changes pending in a digest which is no longer
+// configured to the change set, for them to be notified right away
+func (self *UserState) flushDigest() {
+	if self.Digest == nil {
+		return
+	}
+	self.Gdrive.ChangeSet = append(self.Digest.Changes(), self.Gdrive.ChangeSet...)
+	self.Gdrive.Omitted += self.Digest.Omitted
+	self.Digest = nil
+}
+
+func (self *DigestState) IsDue(conf *DigestConfiguration, now time.Time) bool {
+	return !now.Before(conf.NextDelivery(self.LastDeliveryAt))
+}
+
+func (self *DigestState) Reset(now time.Time) {
+	self.Pending = make([]*DigestEntry, 0)
+	self.Omitted = 0
+	self.LastDeliveryAt = now
+}
+
+type digestCount struct {
+	Key   string
+	Count int
+}
+
+type byCountDescending []digestCount
+
+func (self byCountDescending) Len() int      { return len(self) }
+func (self byCountDescending) Swap(i, j int) { self[i], self[j] = self[j], self[i] }
+func (self byCountDescending) Less(i, j int) bool {
+	if self[i].Count != self[j].Count {
+		return self[i].Count > self[j].Count
+	}
+	return self[i].Key < self[j].Key
+}
+
+func countBy(changes []*drive.ChangeItem, key func(*drive.ChangeItem) string) []digestCount {
+	counts := make(map[string]int)
+	for _, change := range changes {
+		counts[key(change)]++
+	}
+	sorted := make([]digestCount, 0, len(counts))
+	for k, c := range counts {
+		sorted = append(sorted, digestCount{k, c})
+	}
+	sort.Sort(byCountDescending(sorted))
+	return sorted
+}
+
+func digestSummaryField(title string, counts []digestCount) string {
+	lines := []string{"*" + title + "*"}
+	for i, c := range counts {
+		if i == maxDigestSummaryEntries {
+			lines = append(lines, fmt.Sprintf("… and %d more", len(counts)-i))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("%s: %d", c.Key, c.Count))
+	}
+	field := strings.Join(lines, "\n")
+	if runes := []rune(field); len(runes) > maxDigestSummaryFieldLength {
+		field = string(runes[:maxDigestSummaryFieldLength-1]) + "…"
+	}
+	return field
+}
+
+func digestSummaryAttachment(title string, counts []digestCount) slack.Attachment {
+	fields := make([]slack.Field, 0, len(counts))
+	for i, c := range counts {
+		if i == maxDigestSummaryEntries {
+			fields = append(fields, slack.Field{
+				Title: fmt.Sprintf("… and %d more", len(counts)-i),
+				Short: true,
+			})
+			break
+		}
+		fields = append(fields, slack.Field{
+			Title: c.Key,
+			Value: fmt.Sprintf("%d", c.Count),
+			Short: true,
+		})
+	}
+	return slack.Attachment{
+		Fallback: title,
+		Title:    title,
+		Fields:   fields,
+	}
+}
+
+// CreateSlackDigestMessages yields a message for every channel routed to by
+// the pending changes, grouping them by action, editor and folder and listing
+// the most recent ones.
+func CreateSlackDigestMessages(subscription *Subscription, digest *DigestState, folders *drive.Folders, editors map[string]string, version string) []*slack.Message {
+	channels, routed := RouteChanges(subscription, digest.Changes(), folders)
+	messages := make([]*slack.Message, 0, len(channels))
+	for _, channel := range channels {
+		changes := routed[channel]
+		text := fmt.Sprintf("%s digest of gdrive activity (configured by @%s): %d changes", strings.Title(subscription.Digest.Frequency), preventNotification(subscription.SlackUserInfo.User), len(changes))
+		byAction := countBy(changes, func(change *drive.ChangeItem) string {
+			return change.LastAction.String()
+		})
+		byEditor := countBy(changes, editorOf)
+		byFolder := countBy(changes, func(change *drive.ChangeItem) string {
+			return folderOf(change, folders)
+		})
+		listed := changes
+		if len(listed) > maxDigestAttachments {
+			listed = listed[len(listed)-maxDigestAttachments:]
+			text = fmt.Sprintf("%s\nshowing the latest %d", text, maxDigestAttachments)
+		}
+		if digest.Omitted > 0 {
+			text = fmt.Sprintf("%s\nup to %d more changes omitted", text, digest.Omitted)
+		}
+		prototype := slack.Message{
+			Channel:  channel,
+			Username: "Google Drive",
+			Text:     text,
+			IconUrl:  fmt.Sprintf("http://gdrive2slack.optionfactory.net/gdrive2slack.png?ck=%s", version),
+		}
+		if subscription.Renderer == BlocksRenderer {
+			summary := slack.NewSectionBlock(text, digestSummaryField("By action", byAction), digestSummaryField("By editor", byEditor), digestSummaryField("By folder", byFolder))
+			header := []slack.Block{summary, slack.NewDividerBlock()}
+			groups := changeBlockGroups(listed, folders, editors)
+			for i := range groups {
+				groups[i] = withMuteActions(subscription, listed[i], groups[i])
+			}
+			messages = append(messages, splitMessage(prototype, nil, chunkBlocks(header, groups))...)
+		} else {
+			attachments := []slack.Attachment{
+				digestSummaryAttachment("By action", byAction),
+				digestSummaryAttachment("By editor", byEditor),
+				digestSummaryAttachment("By folder", byFolder),
+			}
+			attachments = append(attachments, changeAttachments(listed, editors)...)
+			messages = append(messages, splitMessage(prototype, chunkAttachments(attachments), nil)...)
+		}
+	}
+	return messages
+}
@@ -0,0 +1,138 @@
+package gdrive2slack
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/optionfactory/gdrive2slack/google/drive"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDailyDigestsAreDeliveredAtTheConfiguredLocalHour(t *testing.T) {
+	conf := &DigestConfiguration{Frequency: DailyDigest, Hour: 9, TimeZone: "Europe/Rome"}
+	rome, _ := time.LoadLocation("Europe/Rome")
+	before := time.Date(2015, 3, 10, 8, 30, 0, 0, rome)
+	after := time.Date(2015, 3, 10, 9, 30, 0, 0, rome)
+	if next := conf.NextDelivery(before); !next.Equal(time.Date(2015, 3, 10, 9, 0, 0, 0, rome)) {
+		t.Error(next)
+	}
+	if next := conf.NextDelivery(after); !next.Equal(time.Date(2015, 3, 11, 9, 0, 0, 0, rome)) {
+		t.Error(next)
+	}
+}
+
+func TestWeeklyDigestsAreDeliveredOnTheConfiguredWeekday(t *testing.T) {
+	conf := &DigestConfiguration{Frequency: WeeklyDigest, Hour: 9, Weekday: time.Monday}
+	tuesday := time.Date(2015, 3, 10, 12, 0, 0, 0, time.UTC)
+	monday := time.Date(2015, 3, 16, 8, 0, 0, 0, time.UTC)
+	if next := conf.NextDelivery(tuesday); !next.Equal(time.Date(2015, 3, 16, 9, 0, 0, 0, time.UTC)) {
+		t.Error(next)
+	}
+	if next := conf.NextDelivery(monday); !next.Equal(time.Date(2015, 3, 16, 9, 0, 0, 0, time.UTC)) {
+		t.Error(next)
+	}
+}
+
+func TestDigestsAreDueOnceTheNextDeliveryIsReached(t *testing.T) {
+	conf := &DigestConfiguration{Frequency: DailyDigest, Hour: 9}
+	state := NewDigestState(time.Date(2015, 3, 10, 10, 0, 0, 0, time.UTC))
+	if state.IsDue(conf, time.Date(2015, 3, 11, 8, 59, 0, 0, time.UTC)) || !state.IsDue(conf, time.Date(2015, 3, 11, 9, 0, 0, 0, time.UTC)) {
+		t.Fail()
+	}
+}
+
+func TestPendingDigestChangesSurviveSerialization(t *testing.T) {
+	state := NewDigestState(time.Now())
+	state.Add([]drive.ChangeItem{aChange("", "design", drive.Deleted)}, 3)
+	serialized, _ := json.Marshal(state)
+	var deserialized DigestState
+	if err := json.Unmarshal(serialized, &deserialized); err != nil {
+		t.Fatal(err)
+	}
+	changes := deserialized.Changes()
+	if len(changes) != 1 || changes[0].LastAction != drive.Deleted || changes[0].File.Parents[0].Id != "design" || deserialized.Omitted != 3 {
+		t.Error(string(serialized))
+	}
+}
+
+func TestPendingDigestChangesAreBounded(t *testing.T) {
+	state := NewDigestState(time.Now())
+	changes := make([]drive.ChangeItem, maxPendingDigestChanges+5)
+	state.Add(changes, 0)
+	if len(state.Pending) != maxPendingDigestChanges || state.Omitted != 5 {
+		t.Fail()
+	}
+}
+
+func TestPendingDigestChangesAreNotifiedOnceDigestsAreOff(t *testing.T) {
+	state := &UserState{Gdrive: &drive.State{ChangeSet: []drive.ChangeItem{aChange("", "finance", drive.Created)}}}
+	state.flushDigest()
+	state.Digest = NewDigestState(time.Now())
+	state.Digest.Add([]drive.ChangeItem{aChange("", "design", drive.Modified)}, 2)
+	state.flushDigest()
+	if state.Digest != nil || len(state.Gdrive.ChangeSet) != 2 || state.Gdrive.ChangeSet[0].File.Parents[0].Id != "design" || state.Gdrive.Omitted != 2 {
+		t.Error(state.Gdrive)
+	}
+}
+
+func TestDigestMessagesGroupChangesWithCounts(t *testing.T) {
+	subscription := aSubscription()
+	subscription.Digest = &DigestConfiguration{Frequency: DailyDigest}
+	state := NewDigestState(time.Now())
+	state.Add([]drive.ChangeItem{
+		aChange("", "design", drive.Modified),
+		aChange("", "design", drive.Modified),
+		aChange("", "finance", drive.Created),
+	}, 0)
+	messages := CreateSlackDigestMessages(subscription, state, routingFolders, nil, "test")
+	if len(messages) != 1 {
+		t.Fatal(messages)
+	}
+	byAction := messages[0].Attachments[0]
+	if byAction.Fields[0].Title != "Modified" || byAction.Fields[0].Value != "2" || byAction.Fields[1].Title != "Created" || byAction.Fields[1].Value != "1" {
+		t.Error(byAction)
+	}
+	byFolder := messages[0].Attachments[2]
+	if byFolder.Fields[0].Title != "design" || byFolder.Fields[0].Value != "2" {
+		t.Error(byFolder)
+	}
+	if len(messages[0].Attachments) != 3+3 {
+		t.Error(len(messages[0].Attachments))
+	}
+}
+
+func TestLargeDigestSummariesFitSlackFields(t *testing.T) {
+	subscription := aSubscription()
+	subscription.Renderer = BlocksRenderer
+	subscription.Digest = &DigestConfiguration{Frequency: DailyDigest}
+	changes := make([]drive.ChangeItem, 0, maxPendingDigestChanges)
+	for i := 0; i != maxPendingDigestChanges; i++ {
+		change := aChange("", fmt.Sprintf("folder-%d", i), drive.Modified)
+		change.File.LastModifyingUser = drive.User{DisplayName: fmt.Sprintf("An editor with a rather long name, number %d", i)}
+		changes = append(changes, change)
+	}
+	state := NewDigestState(time.Now())
+	state.Add(changes, 0)
+	summary := CreateSlackDigestMessages(subscription, state, routingFolders, nil, "test")[0].Blocks[0]
+	for _, field := range summary.Fields {
+		if len([]rune(field.Text)) > maxDigestSummaryFieldLength {
+			t.Error(len(field.Text))
+		}
+	}
+	if byEditor := summary.Fields[1].Text; !strings.HasSuffix(byEditor, fmt.Sprintf("… and %d more", maxPendingDigestChanges-maxDigestSummaryEntries)) {
+		t.Error(byEditor)
+	}
+}
+
+func TestDigestBlocksOfferToMuteTheListedChanges(t *testing.T) {
+	subscription := aSubscription()
+	subscription.Renderer = BlocksRenderer
+	subscription.Digest = &DigestConfiguration{Frequency: DailyDigest}
+	state := NewDigestState(time.Now())
+	state.Add([]drive.ChangeItem{aChange("", "design", drive.Modified)}, 0)
+	blocks := CreateSlackDigestMessages(subscription, state, routingFolders, nil, "test")[0].Blocks
+	if last := blocks[len(blocks)-1]; last.Type != "actions" {
+		t.Error(last)
+	}
+}
@@ -0,0 +1,112 @@
+package gdrive2slack
+
+import (
+	"fmt"
+	"github.com/optionfactory/gdrive2slack/google/drive"
+	"github.com/optionfactory/gdrive2slack/slack"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// NameEditors renders editors found in the slack team with their profile
+	// name, without notifying them
+	NameEditors = "name"
+	// PingEditors renders editors found in the slack team as <@U123> mentions,
+	// notifying them of every change they make: it has to be asked for
+	PingEditors = "ping"
+)
+
+const slackUserCacheTtl = time.Hour
+
+type slackUserLookup func(accessToken string, email string) (*slack.User, slack.StatusCode, error)
+
+type slackUserCacheEntry struct {
+	user      *slack.User
+	expiresAt time.Time
+}
+
+// SlackUserCache maps google emails to slack users of a team, remembering
+// users not in the team as well. It is shared by the workers.
+type SlackUserCache struct {
+	lock    sync.Mutex
+	ttl     time.Duration
+	lookup  slackUserLookup
+	entries map[string]*slackUserCacheEntry
+	// missingScope holds until when tokens lacking users:read.email are not used
+	missingScope map[string]time.Time
+}
+
+func NewSlackUserCache(ttl time.Duration, lookup slackUserLookup) *SlackUserCache {
+	return &SlackUserCache{
+		ttl:          ttl,
+		lookup:       lookup,
+		entries:      make(map[string]*slackUserCacheEntry),
+		missingScope: make(map[string]time.Time),
+	}
+}
+
+// Get returns the user with the given email in the team, or nil when not
+// found. Lookup errors other than a missing user are not cached, except for
+// tokens granted before editors could be looked up: their missing scope is
+// reported once, then they find no user until the ttl expires.
+func (self *SlackUserCache) Get(teamId string, accessToken string, email string, now time.Time) (*slack.User, error) {
+	key := teamId + "|" + strings.ToLower(email)
+	self.lock.Lock()
+	entry, found := self.entries[key]
+	missingScopeUntil := self.missingScope[accessToken]
+	self.lock.Unlock()
+	if found && now.Before(entry.expiresAt) {
+		return entry.user, nil
+	}
+	if now.Before(missingScopeUntil) {
+		return nil, nil
+	}
+	user, status, err := self.lookup(accessToken, email)
+	if status == slack.MissingScope {
+		self.lock.Lock()
+		self.missingScope[accessToken] = now.Add(self.ttl)
+		self.lock.Unlock()
+		return nil, err
+	}
+	if status != slack.Ok && status != slack.UsersNotFound {
+		return nil, err
+	}
+	self.lock.Lock()
+	self.entries[key] = &slackUserCacheEntry{user, now.Add(self.ttl)}
+	self.lock.Unlock()
+	return user, nil
+}
+
+// ResolveEditors renders the editors of the changes as configured by the
+// subscription, keyed by lower case email. Editors missing from the result
+// are rendered as usual.
+func ResolveEditors(env *Environment, subscription *Subscription, changes []drive.ChangeItem) map[string]string {
+	editors := make(map[string]string)
+	if subscription.EditorMentions != PingEditors && subscription.EditorMentions != NameEditors {
+		return editors
+	}
+	now := time.Now()
+	for _, change := range changes {
+		email := strings.ToLower(change.File.LastModifyingUser.EmailAddress)
+		if _, resolved := editors[email]; resolved || email == "" {
+			continue
+		}
+		user, err := env.SlackUsers.Get(subscription.SlackUserInfo.TeamId, subscription.SlackAccessToken, email, now)
+		if err != nil {
+			env.Logger.Warning("[%s/%s] cannot lookup slack user: %s", subscription.GoogleUserInfo.Email, subscription.SlackUserInfo.User, err)
+			// the other lookups would most likely fail as well
+			return editors
+		}
+		if user == nil {
+			continue
+		}
+		if subscription.EditorMentions == PingEditors {
+			editors[email] = fmt.Sprintf("<@%s>", user.Id)
+		} else {
+			editors[email] = preventNotification(user.VisibleName())
+		}
+	}
+	return editors
+}
@@ -0,0 +1,124 @@
+package gdrive2slack
+
+import (
+	"errors"
+	"github.com/optionfactory/gdrive2slack/google/drive"
+	"github.com/optionfactory/gdrive2slack/slack"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+type fakeSlackDirectory struct {
+	users   map[string]*slack.User
+	lookups int
+	failing bool
+}
+
+func (self *fakeSlackDirectory) lookup(accessToken string, email string) (*slack.User, slack.StatusCode, error) {
+	self.lookups++
+	if self.failing {
+		return nil, slack.RateLimited, errors.New("rate_limited")
+	}
+	if user, found := self.users[email]; found {
+		return user, slack.Ok, nil
+	}
+	return nil, slack.UsersNotFound, errors.New("users_not_found")
+}
+
+func aSlackDirectory() *fakeSlackDirectory {
+	return &fakeSlackDirectory{
+		users: map[string]*slack.User{
+			"jane@example.com": {Id: "U123", Name: "jane", Profile: slack.Profile{DisplayName: "Jane"}},
+		},
+	}
+}
+
+func TestSlackUsersAreCachedUntilTheTtlExpires(t *testing.T) {
+	directory := aSlackDirectory()
+	cache := NewSlackUserCache(time.Hour, directory.lookup)
+	now := time.Now()
+	cache.Get("T1", "token", "jane@example.com", now)
+	user, _ := cache.Get("T1", "token", "JANE@example.com", now.Add(time.Minute))
+	if user == nil || user.Id != "U123" || directory.lookups != 1 {
+		t.Fail()
+	}
+	cache.Get("T1", "token", "jane@example.com", now.Add(2*time.Hour))
+	if directory.lookups != 2 {
+		t.Fail()
+	}
+}
+
+func TestUsersNotInTheTeamAreCached(t *testing.T) {
+	directory := aSlackDirectory()
+	cache := NewSlackUserCache(time.Hour, directory.lookup)
+	now := time.Now()
+	for i := 0; i != 2; i++ {
+		if user, err := cache.Get("T1", "token", "stranger@example.com", now); user != nil || err != nil {
+			t.Fail()
+		}
+	}
+	if directory.lookups != 1 {
+		t.Fail()
+	}
+}
+
+func TestFailedLookupsAreNotCached(t *testing.T) {
+	directory := aSlackDirectory()
+	directory.failing = true
+	cache := NewSlackUserCache(time.Hour, directory.lookup)
+	now := time.Now()
+	if _, err := cache.Get("T1", "token", "jane@example.com", now); err == nil {
+		t.Fail()
+	}
+	directory.failing = false
+	if user, _ := cache.Get("T1", "token", "jane@example.com", now); user == nil {
+		t.Fail()
+	}
+}
+
+func TestTokensMissingTheScopeAreNotUsedUntilTheTtlExpires(t *testing.T) {
+	lookups := 0
+	cache := NewSlackUserCache(time.Hour, func(accessToken string, email string) (*slack.User, slack.StatusCode, error) {
+		lookups++
+		return nil, slack.MissingScope, errors.New("missing_scope")
+	})
+	now := time.Now()
+	if _, err := cache.Get("T1", "token", "jane@example.com", now); err == nil {
+		t.Error("missing scope not reported")
+	}
+	if user, err := cache.Get("T1", "token", "john@example.com", now.Add(time.Minute)); user != nil || err != nil || lookups != 1 {
+		t.Error(lookups, err)
+	}
+	cache.Get("T1", "other-token", "jane@example.com", now)
+	cache.Get("T1", "token", "jane@example.com", now.Add(2*time.Hour))
+	if lookups != 3 {
+		t.Error(lookups)
+	}
+}
+
+func TestResolvedEditorsAreRenderedAsConfigured(t *testing.T) {
+	env := &Environment{
+		Logger:     NewLogger(ioutil.Discard, "", 0),
+		SlackUsers: NewSlackUserCache(time.Hour, aSlackDirectory().lookup),
+	}
+	changes := []drive.ChangeItem{
+		{File: drive.ChangedFile{LastModifyingUser: drive.User{DisplayName: "Jane Doe", EmailAddress: "jane@example.com"}}},
+		{File: drive.ChangedFile{LastModifyingUser: drive.User{DisplayName: "Stranger", EmailAddress: "stranger@example.com"}}},
+	}
+	subscription := aSubscription()
+	subscription.EditorMentions = PingEditors
+	mentions := ResolveEditors(env, subscription, changes)
+	if formatEditor(&changes[0], mentions) != "<@U123>" || formatEditor(&changes[1], mentions) != "<mailto:stranger@example.com|S​tranger>" {
+		t.Error(mentions)
+	}
+	subscription.EditorMentions = NameEditors
+	names := ResolveEditors(env, subscription, changes)
+	if formatEditor(&changes[0], names) != "J​ane" {
+		t.Error(names)
+	}
+	subscription.EditorMentions = ""
+	if len(ResolveEditors(env, subscription, changes)) != 0 {
+		t.Fail()
+	}
+}
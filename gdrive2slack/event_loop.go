@@ -10,11 +10,17 @@ import (
 )
 
 func EventLoop(env *Environment) {
-	subscriptions, err := LoadSubscriptions("subscriptions.json")
+	store, err := OpenStore(env.Configuration.Store)
 	if err != nil {
-		env.Logger.Error("unreadable subscriptions file: %s", err)
+		env.Logger.Error("cannot open subscriptions store: %s", err)
 		os.Exit(1)
 	}
+	subscriptions, err := LoadSubscriptions(store)
+	if err != nil {
+		env.Logger.Error("unreadable subscriptions store: %s", err)
+		os.Exit(1)
+	}
+	env.Health.SubscriptionsLoaded(time.Now())
 
 	lastLoopTime := time.Time{}
 	waitFor := time.Duration(0)
@@ -27,58 +33,161 @@ func EventLoop(env *Environment) {
 		}
 		select {
 		case subscriptionAndAccessToken := <-env.RegisterChannel:
-			subscription := subscriptionAndAccessToken.Subscription
-			alreadySubscribed := subscriptions.Contains(subscription.GoogleUserInfo.Email)
-			subscriptions.Add(subscription, subscriptionAndAccessToken.GoogleAccessToken)
-			if alreadySubscribed {
-				env.Logger.Info("[%s/%s] *subscription: '%s' '%s'", subscription.GoogleUserInfo.Email, subscription.SlackUserInfo.User, subscription.GoogleUserInfo.GivenName, subscription.GoogleUserInfo.FamilyName)
-			} else {
-				env.Logger.Info("[%s/%s] +subscription: '%s' '%s'", subscription.GoogleUserInfo.Email, subscription.SlackUserInfo.User, subscription.GoogleUserInfo.GivenName, subscription.GoogleUserInfo.FamilyName)
-				go mailchimpRegistrationTask(env, subscription)
+			register(env, subscriptions, subscriptionAndAccessToken)
+		case command := <-env.CommandChannel:
+			command(subscriptions)
+		case <-env.Stopping:
+			shutdown(env, store, subscriptions)
+			return
+		case notification := <-env.NotificationChannel:
+			keys := notifiedSubscriptions(env, subscriptions, notification)
+			if len(keys) == 0 {
+				continue
 			}
-		case s := <-env.SignalsChannel:
-			env.Logger.Info("Exiting: got signal %v", s)
-			os.Exit(0)
+			served, failures, removals := serve(env, subscriptions, keys)
+			env.Logger.Info("Served %d notified clients with %d failures and %d removals", served, failures, removals)
 		case <-time.After(waitFor):
 			lastLoopTime = time.Now()
+			env.Logger.Info("Starting to serve %d clients", len(subscriptions.Info))
+			served, failures, removals := serve(env, subscriptions, subscriptions.ActiveKeys())
+			env.Logger.Info("Served %d clients with %d failures and %d removals", served, failures, removals)
+			env.Metrics.ObserveLoop(time.Now().Sub(lastLoopTime))
+			env.Health.LoopCompleted(time.Now())
+		}
+		if keys := subscriptions.TakeDue(); len(keys) != 0 {
+			served, failures, removals := serve(env, subscriptions, keys)
+			env.Logger.Info("Served %d due clients with %d failures and %d removals", served, failures, removals)
+		}
+		env.Metrics.SetSubscriptions(subscriptions)
+	}
+}
 
-			subsLen := len(subscriptions.Info)
-			env.Logger.Info("Starting to serve %d clients", subsLen)
-			requests := make(chan *subscriptionAndUserState, subsLen)
-			responses := make(chan response, subsLen)
+func register(env *Environment, subscriptions *Subscriptions, subscriptionAndAccessToken *SubscriptionAndAccessToken) {
+	subscription := subscriptionAndAccessToken.Subscription
+	knownAccount := subscriptions.ContainsEmail(subscription.GoogleUserInfo.Email)
+	if existing, found := subscriptions.Replaced(subscription); found {
+		subscription.keepSettings(existing, subscriptionAndAccessToken.KeptSettings)
+	}
+	previous := subscriptions.States[subscription.Id]
+	replaced, err := subscriptions.Add(subscription, subscriptionAndAccessToken.GoogleAccessToken)
+	if replaced {
+		stopWatchChannel(env, subscriptionAndAccessToken.GoogleAccessToken, previous.Watch)
+	}
+	env.Health.StoreWritten(err)
+	if err != nil {
+		env.Logger.Warning("[%s/%s] cannot store subscription: %s", subscription.GoogleUserInfo.Email, subscription.SlackUserInfo.User, err)
+	}
+	if replaced {
+		env.Logger.Info("[%s/%s] *subscription %s: '%s' '%s'", subscription.GoogleUserInfo.Email, subscription.SlackUserInfo.User, subscription.Id, subscription.GoogleUserInfo.GivenName, subscription.GoogleUserInfo.FamilyName)
+	} else {
+		env.Logger.Info("[%s/%s] +subscription %s: '%s' '%s'", subscription.GoogleUserInfo.Email, subscription.SlackUserInfo.User, subscription.Id, subscription.GoogleUserInfo.GivenName, subscription.GoogleUserInfo.FamilyName)
+	}
+	if !knownAccount {
+		go mailchimpRegistrationTask(env, subscription)
+	}
+}
 
-			for w := 0; w != env.Configuration.Workers; w++ {
-				go worker(w, env, requests, responses)
-			}
+// serve polls the subscriptions with the given keys. Commands received
+// meanwhile are run as soon as the subscriptions being polled are done, the
+// others waiting for the commands to complete: a command never runs while the
+// subscription it changes is being polled, nor does it wait for all of them.
+func serve(env *Environment, subscriptions *Subscriptions, keys []string) (int, int, int) {
+	requests := make(chan *subscriptionAndUserState)
+	responses := make(chan response)
+	for w := 0; w != env.Configuration.Workers; w++ {
+		go worker(w, env, requests, responses)
+	}
+	defer close(requests)
 
-			for k, subscription := range subscriptions.Info {
-				requests <- &subscriptionAndUserState{
-					subscription,
-					subscriptions.States[k],
-				}
+	commands := make([]func(*Subscriptions), 0)
+	next := 0
+	inFlight := 0
+	served := 0
+	failures := 0
+	removals := 0
+	for {
+		// commands can remove or pause the subscriptions yet to be polled
+		for next != len(keys) && !subscriptions.IsActive(keys[next]) {
+			next++
+		}
+		if next == len(keys) && inFlight == 0 {
+			break
+		}
+		var dispatch chan<- *subscriptionAndUserState
+		var request *subscriptionAndUserState
+		if next != len(keys) && len(commands) == 0 {
+			dispatch = requests
+			request = &subscriptionAndUserState{
+				subscriptions.Info[keys[next]],
+				subscriptions.States[keys[next]],
+			}
+		}
+		select {
+		case dispatch <- request:
+			next++
+			inFlight++
+		case command := <-env.CommandChannel:
+			commands = append(commands, command)
+		case response := <-responses:
+			inFlight--
+			if response.Skipped {
+				break
+			}
+			served++
+			failed, removed := handleResponse(env, subscriptions, response)
+			if failed {
+				failures++
 			}
-			close(requests)
-			failures := 0
-			removals := 0
-			for r := 0; r != subsLen; r++ {
-				response := <-responses
-				if response.Success {
-					subscriptions.HandleSuccess(response.Email)
-				} else {
-					failures++
-					subscription, message, removed := subscriptions.HandleFailure(response.Email)
-					if removed {
-						removals++
-						env.Logger.Info("[%s/%s] -subscription: '%s' '%s' %s", response.Email, subscription.SlackUserInfo.User, subscription.GoogleUserInfo.GivenName, subscription.GoogleUserInfo.FamilyName, message)
-						go mailchimpDeregistrationTask(env, subscription)
-					} else {
-						env.Logger.Info("[%s/%s] !subscription: '%s' '%s' %s", response.Email, subscription.SlackUserInfo.User, subscription.GoogleUserInfo.GivenName, subscription.GoogleUserInfo.FamilyName, message)
-					}
-				}
+			if removed {
+				removals++
 			}
-			env.Logger.Info("Served %d clients with %d failures and %d removals", subsLen, failures, removals)
+		}
+		if len(commands) != 0 && inFlight == 0 {
+			for _, command := range commands {
+				command(subscriptions)
+			}
+			commands = commands[:0]
 		}
 	}
+	err := subscriptions.SaveStates()
+	if err != nil {
+		env.Logger.Warning("cannot save subscription states: %s", err)
+	}
+	env.Health.StoreWritten(err)
+	env.Metrics.CountPolls(served, failures, removals)
+	return served, failures, removals
+}
+
+// handleResponse records the outcome of polling a subscription, yielding
+// whether it failed and whether it was removed after failing for too long
+func handleResponse(env *Environment, subscriptions *Subscriptions, response response) (bool, bool) {
+	defer func() {
+		if response.Changed && subscriptions.Contains(response.Key) {
+			if err := subscriptions.Save(response.Key); err != nil {
+				env.Logger.Warning("cannot save subscription %s: %s", response.Key, err)
+			}
+		}
+	}()
+	if response.Success {
+		subscriptions.HandleSuccess(response.Key)
+		return false, false
+	}
+	state := subscriptions.States[response.Key]
+	subscription, message, removed, err := subscriptions.HandleFailure(response.Key)
+	email := subscription.GoogleUserInfo.Email
+	if err != nil {
+		env.Logger.Warning("[%s/%s] cannot delete subscription %s: %s", email, subscription.SlackUserInfo.User, response.Key, err)
+	}
+	if !removed {
+		env.Logger.Info("[%s/%s] !subscription %s: '%s' '%s' %s", email, subscription.SlackUserInfo.User, response.Key, subscription.GoogleUserInfo.GivenName, subscription.GoogleUserInfo.FamilyName, message)
+		return true, false
+	}
+	stopWatchChannel(env, state.GoogleAccessToken, state.Watch)
+	env.Logger.Info("[%s/%s] -subscription %s: '%s' '%s' %s", email, subscription.SlackUserInfo.User, response.Key, subscription.GoogleUserInfo.GivenName, subscription.GoogleUserInfo.FamilyName, message)
+	if !subscriptions.ContainsEmail(email) {
+		go mailchimpDeregistrationTask(env, subscription)
+	}
+	return true, true
 }
 
 type subscriptionAndUserState struct {
@@ -87,12 +196,20 @@ type subscriptionAndUserState struct {
 }
 
 type response struct {
-	Email   string
+	Key     string
 	Success bool
+	// Changed tells the subscription itself, not only its state, has to be saved
+	Changed bool
+	// Skipped tells the subscription was not served, the service stopping
+	Skipped bool
 }
 
 func worker(id int, env *Environment, subAndStates <-chan *subscriptionAndUserState, responses chan<- response) {
 	for subAndState := range subAndStates {
+		if env.IsStopping() {
+			responses <- response{Key: subAndState.Subscription.Id, Skipped: true}
+			continue
+		}
 		responses <- serveUserTask(env, subAndState.Subscription, subAndState.UserState)
 	}
 }
@@ -101,7 +218,7 @@ func serveUserTask(env *Environment, subscription *Subscription, userState *User
 	email := subscription.GoogleUserInfo.Email
 	slackUser := subscription.SlackUserInfo.User
 	result = response{
-		Email:   email,
+		Key:     subscription.Id,
 		Success: true,
 	}
 	defer func() {
@@ -110,42 +227,153 @@ func serveUserTask(env *Environment, subscription *Subscription, userState *User
 			result.Success = false
 		}
 	}()
+	result.Changed = serveDrive(env, subscription, userState)
+	// queued messages are delivered whatever the outcome of polling drive
+	if deliverOutbox(env, subscription, userState) {
+		result.Changed = true
+	}
+	return
+}
+
+// serveDrive detects the changes on drive and queues their notifications,
+// returning true when the subscription itself changed. Changes are left on
+// drive while the outbox is full.
+func serveDrive(env *Environment, subscription *Subscription, userState *UserState) bool {
+	email := subscription.GoogleUserInfo.Email
+	slackUser := subscription.SlackUserInfo.User
 	var err error
-	if userState.Gdrive.LargestChangeId == 0 {
+	if userState.Gdrive.PageToken == "" {
 
 		userState.GoogleAccessToken, err = google.DoWithAccessToken(env.Configuration.Google, env.HttpClient, subscription.GoogleRefreshToken, userState.GoogleAccessToken, func(at string) (google.StatusCode, error) {
-			return drive.LargestChangeId(env.HttpClient, userState.Gdrive, at)
+			started := time.Now()
+			statusCode, err := drive.StartPageToken(env.HttpClient, userState.Gdrive, at)
+			env.Metrics.ObserveDrive("start_page_token", started, statusCode)
+			return statusCode, err
 		})
 		if err != nil {
 			env.Logger.Warning("[%s/%s] %s", email, slackUser, err)
 		}
-		return
+		return false
+	}
+	if len(userState.Outbox) >= maxOutboxMessages {
+		env.Logger.Info("[%s/%s] %d messages undelivered, leaving changes on drive", email, slackUser, len(userState.Outbox))
+		return false
 	}
 
 	userState.GoogleAccessToken, err = google.DoWithAccessToken(env.Configuration.Google, env.HttpClient, subscription.GoogleRefreshToken, userState.GoogleAccessToken, func(at string) (google.StatusCode, error) {
-		return drive.DetectChanges(env.HttpClient, userState.Gdrive, at)
+		started := time.Now()
+		statusCode, err := drive.DetectChanges(env.HttpClient, userState.Gdrive, at, env.Configuration.MaxChangesPerPoll, subscription.UpdateInPlace)
+		env.Metrics.ObserveDrive("changes", started, statusCode)
+		return statusCode, err
 	})
 	if err != nil {
 		env.Logger.Warning("[%s/%s] %s", email, slackUser, err)
-		return
+		return false
 	}
+	env.Metrics.CountChanges(userState.Gdrive.ChangeSet)
 
+	if env.Configuration.Push.IsPushConfigured() {
+		renewWatchChannel(env, subscription, userState)
+	}
+	changed := refreshChannels(env, subscription, userState, time.Now())
+
+	if subscription.Digest.IsDigestConfigured() {
+		serveDigest(env, subscription, userState)
+	} else {
+		userState.flushDigest()
+		serveChanges(env, subscription, userState)
+	}
+	return changed
+}
+
+// serveChanges queues a message for the changes detected by this poll
+func serveChanges(env *Environment, subscription *Subscription, userState *UserState) {
+	email := subscription.GoogleUserInfo.Email
+	slackUser := subscription.SlackUserInfo.User
+	now := time.Now()
+	userState.expireFileThreads(subscription.FileThreads, now)
+	userState.expireFileMessages(subscription.UpdateInPlace, now)
 	if len(userState.Gdrive.ChangeSet) == 0 {
 		return
 	}
+	started := time.Now()
 	statusCode, err, folders := drive.FetchFolders(env.HttpClient, userState.GoogleAccessToken)
+	env.Metrics.ObserveDrive("folders", started, statusCode)
 	if statusCode != google.Ok {
 		env.Logger.Warning("[%s/%s] while fetching folders: %s", email, slackUser, err)
 		return
 	}
-	message := CreateSlackMessage(subscription, userState, folders, env.Version)
-	if len(message.Attachments) == 0 {
+	editors := ResolveEditors(env, subscription, userState.Gdrive.ChangeSet)
+	if subscription.UpdateInPlace {
+		entries := CreateSlackFileEntries(subscription, userState, folders, editors, env.Version, now)
+		for _, entry := range entries {
+			if entry.Update != nil {
+				env.Logger.Info("[%s/%s] @%v updating %s in %s", email, slackUser, userState.Gdrive.PageToken, entry.Update.Ts, subscription.ChannelName(entry.Message.Channel))
+			} else {
+				env.Logger.Info("[%s/%s] @%v 1 change to %s", email, slackUser, userState.Gdrive.PageToken, subscription.ChannelName(entry.Message.Channel))
+			}
+		}
+		enqueueEntries(userState, entries)
 		return
 	}
+	messages, fileIds := CreateSlackMessages(subscription, userState, folders, editors, env.Version, now)
+	for _, message := range messages {
+		env.Logger.Info("[%s/%s] @%v %v changes to %s", email, slackUser, userState.Gdrive.PageToken, len(message.Attachments), subscription.ChannelName(message.Channel))
+	}
+	enqueueMessages(subscription, userState, messages, fileIds)
+}
 
-	env.Logger.Info("[%s/%s] @%v %v changes", email, slackUser, userState.Gdrive.LargestChangeId, len(message.Attachments))
+// serveDigest accumulates the detected changes and queues them once the
+// digest of the subscription is due.
+func serveDigest(env *Environment, subscription *Subscription, userState *UserState) {
+	email := subscription.GoogleUserInfo.Email
+	slackUser := subscription.SlackUserInfo.User
+	now := time.Now()
+	if userState.Digest == nil {
+		userState.Digest = NewDigestState(now)
+	}
+	due := userState.Digest.IsDue(subscription.Digest, now)
+	if len(userState.Gdrive.ChangeSet) == 0 && !(due && len(userState.Digest.Pending) != 0) {
+		if due {
+			userState.Digest.Reset(now)
+		}
+		return
+	}
+	started := time.Now()
+	statusCode, err, folders := drive.FetchFolders(env.HttpClient, userState.GoogleAccessToken)
+	env.Metrics.ObserveDrive("folders", started, statusCode)
+	if statusCode != google.Ok {
+		env.Logger.Warning("[%s/%s] while fetching folders: %s", email, slackUser, err)
+		return
+	}
+	userState.Digest.Add(UnmutedChanges(subscription.Mutes, FilterChanges(subscription.Rules, userState.Gdrive.ChangeSet, folders), folders, now), userState.Gdrive.Omitted)
+	if !due {
+		return
+	}
+	editors := ResolveEditors(env, subscription, userState.Digest.Changes())
+	messages := CreateSlackDigestMessages(subscription, userState.Digest, folders, editors, env.Version)
+	for _, message := range messages {
+		env.Logger.Info("[%s/%s] digest of %v changes to %s", email, slackUser, len(userState.Digest.Pending), subscription.ChannelName(message.Channel))
+	}
+	enqueueMessages(subscription, userState, messages, nil)
+	userState.Digest.Reset(now)
+}
 
-	status, err := slack.PostMessage(env.HttpClient, subscription.SlackAccessToken, message)
+// postMessage yields the outcome of posting message, or of replacing the
+// content of update with it. The notice about an unknown channel is sent at
+// most once.
+func postMessage(env *Environment, subscription *Subscription, message *slack.Message, update *slack.PostedMessage) (*slack.PostedMessage, slack.StatusCode, error) {
+	email := subscription.GoogleUserInfo.Email
+	slackUser := subscription.SlackUserInfo.User
+	var posted *slack.PostedMessage
+	var status slack.StatusCode
+	var err error
+	if update != nil {
+		posted, status, err = slack.UpdateMessage(env.HttpClient, subscription.SlackAccessToken, update, message)
+	} else {
+		posted, status, err = slack.PostMessage(env.HttpClient, subscription.SlackAccessToken, message)
+	}
+	env.Metrics.CountSlackPost(status)
 	if status == slack.NotAuthed || status == slack.InvalidAuth || status == slack.AccountInactive || status == slack.TokenRevoked {
 		panic(err)
 	}
@@ -153,15 +381,16 @@ func serveUserTask(env *Environment, subscription *Subscription, userState *User
 		env.Logger.Warning("[%s/%s] %s", email, slackUser, err)
 	}
 	if status == slack.ChannelNotFound {
-		status, err = slack.PostMessage(env.HttpClient, subscription.SlackAccessToken, CreateSlackUnknownChannelMessage(subscription, env.Configuration.Google.RedirectUri, message))
-		if status == slack.NotAuthed || status == slack.InvalidAuth || status == slack.AccountInactive || status == slack.TokenRevoked {
-			panic(err)
+		_, nstatus, nerr := slack.PostMessage(env.HttpClient, subscription.SlackAccessToken, CreateSlackUnknownChannelMessage(subscription, env.Configuration.Google.RedirectUri, message))
+		env.Metrics.CountSlackPost(nstatus)
+		if nstatus == slack.NotAuthed || nstatus == slack.InvalidAuth || nstatus == slack.AccountInactive || nstatus == slack.TokenRevoked {
+			panic(nerr)
 		}
-		if status != slack.Ok {
-			env.Logger.Warning("[%s/%s] %s", email, slackUser, err)
+		if nstatus != slack.Ok {
+			env.Logger.Warning("[%s/%s] %s", email, slackUser, nerr)
 		}
 	}
-	return
+	return posted, status, err
 }
 
 func mailchimpRegistrationTask(env *Environment, subscription *Subscription) {
@@ -0,0 +1,168 @@
+package gdrive2slack
+
+import (
+	"errors"
+	"fmt"
+	"github.com/optionfactory/gdrive2slack/google/drive"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	IncludeEffect = "include"
+	ExcludeEffect = "exclude"
+)
+
+// Rule includes or excludes the changes matching all of its non empty
+// criteria, each criterion matching when any of its values does:
+//   - Actions: action names, e.g. "Created"
+//   - MimeTypes: mime types, e.g. "application/pdf"
+//   - Titles: glob patterns, or regular expressions when enclosed in slashes
+//   - Editors: email addresses, or domains when starting with "@"
+//   - FolderPaths: glob patterns matched against the folder path of the file
+//     and its ancestors, e.g. "Projects" or "Projects/*/Reports"
+//
+// A rule without criteria matches every change.
+type Rule struct {
+	Effect      string   `json:"effect"`
+	Actions     []string `json:"actions"`
+	MimeTypes   []string `json:"mime_types"`
+	Titles      []string `json:"titles"`
+	Editors     []string `json:"editors"`
+	FolderPaths []string `json:"folder_paths"`
+}
+
+// changeActions are the names of the actions rules and routes can match
+var changeActions = []string{"Deleted", "Created", "Modified", "Shared"}
+
+func isRegexpPattern(pattern string) bool {
+	return len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/")
+}
+
+func (self *Rule) Validate() error {
+	if self.Effect != IncludeEffect && self.Effect != ExcludeEffect {
+		return fmt.Errorf("invalid rule effect: '%s'", self.Effect)
+	}
+	for _, action := range self.Actions {
+		if !containsString(changeActions, action) {
+			return fmt.Errorf("invalid rule action: '%s'", action)
+		}
+	}
+	for _, title := range self.Titles {
+		if isRegexpPattern(title) {
+			if _, err := regexp.Compile(title[1 : len(title)-1]); err != nil {
+				return err
+			}
+		} else if _, err := path.Match(title, ""); err != nil {
+			return fmt.Errorf("invalid title pattern: '%s'", title)
+		}
+	}
+	for _, folderPath := range self.FolderPaths {
+		if _, err := path.Match(strings.Trim(folderPath, "/"), ""); err != nil {
+			return fmt.Errorf("invalid folder path pattern: '%s'", folderPath)
+		}
+	}
+	return nil
+}
+
+func ValidateRules(rules []*Rule) error {
+	for _, rule := range rules {
+		if rule == nil {
+			return errors.New("invalid empty rule")
+		}
+		if err := rule.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func titleMatches(patterns []string, title string) bool {
+	for _, pattern := range patterns {
+		if isRegexpPattern(pattern) {
+			if matched, _ := regexp.MatchString(pattern[1:len(pattern)-1], title); matched {
+				return true
+			}
+		} else if matched, _ := path.Match(pattern, title); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func editorMatches(editors []string, email string) bool {
+	email = strings.ToLower(email)
+	for _, editor := range editors {
+		editor = strings.ToLower(editor)
+		if strings.HasPrefix(editor, "@") && strings.HasSuffix(email, editor) {
+			return true
+		}
+		if editor == email {
+			return true
+		}
+	}
+	return false
+}
+
+func folderPathMatches(patterns []string, parents []drive.Parent, folders *drive.Folders) bool {
+	for _, parent := range parents {
+		fullPath, found := folders.FullPathFor(parent.Id)
+		if !found {
+			continue
+		}
+		segments := strings.Split(fullPath, "/")
+		for i := len(segments); i != 0; i-- {
+			candidate := strings.Join(segments[:i], "/")
+			for _, pattern := range patterns {
+				if matched, _ := path.Match(strings.Trim(pattern, "/"), candidate); matched {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (self *Rule) Matches(change *drive.ChangeItem, folders *drive.Folders) bool {
+	if len(self.Actions) != 0 && !containsString(self.Actions, change.LastAction.String()) {
+		return false
+	}
+	if len(self.MimeTypes) != 0 && !containsString(self.MimeTypes, change.File.MimeType) {
+		return false
+	}
+	if len(self.Titles) != 0 && !titleMatches(self.Titles, change.File.Title) {
+		return false
+	}
+	if len(self.Editors) != 0 && !editorMatches(self.Editors, change.File.LastModifyingUser.EmailAddress) {
+		return false
+	}
+	if len(self.FolderPaths) != 0 && !folderPathMatches(self.FolderPaths, change.File.Parents, folders) {
+		return false
+	}
+	return true
+}
+
+// IsIncluded evaluates the rules in order: the first matching rule decides,
+// changes not matching any rule are included.
+func IsIncluded(rules []*Rule, change *drive.ChangeItem, folders *drive.Folders) bool {
+	for _, rule := range rules {
+		if rule.Matches(change, folders) {
+			return rule.Effect == IncludeEffect
+		}
+	}
+	return true
+}
+
+func FilterChanges(rules []*Rule, changes []drive.ChangeItem, folders *drive.Folders) []drive.ChangeItem {
+	if len(rules) == 0 {
+		return changes
+	}
+	filtered := make([]drive.ChangeItem, 0, len(changes))
+	for i := 0; i != len(changes); i++ {
+		if IsIncluded(rules, &changes[i], folders) {
+			filtered = append(filtered, changes[i])
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,129 @@
+package gdrive2slack
+
+import (
+	"github.com/optionfactory/gdrive2slack/google/drive"
+	"testing"
+)
+
+var filteringFolders = drive.NewFolders(
+	&drive.Folder{Id: "projects", Name: "Projects"},
+	&drive.Folder{Id: "acme", Name: "Acme", Path: "Projects", ParentIds: []string{"projects"}},
+	&drive.Folder{Id: "reports", Name: "Reports", Path: "Projects/Acme", ParentIds: []string{"acme"}},
+	&drive.Folder{Id: "personal", Name: "Personal"},
+)
+
+func TestChangesMatchingNoRuleAreIncluded(t *testing.T) {
+	rules := []*Rule{{Effect: ExcludeEffect, Actions: []string{"Deleted"}}}
+	modified, deleted := aChange("", "acme", drive.Modified), aChange("", "acme", drive.Deleted)
+	if !IsIncluded(rules, &modified, filteringFolders) {
+		t.Fail()
+	}
+	if IsIncluded(rules, &deleted, filteringFolders) {
+		t.Fail()
+	}
+}
+
+func TestFirstMatchingRuleWins(t *testing.T) {
+	rules := []*Rule{
+		{Effect: IncludeEffect, MimeTypes: []string{"application/pdf"}},
+		{Effect: ExcludeEffect},
+	}
+	pdf, doc := aChange("", "acme", drive.Modified), aChange("", "acme", drive.Modified)
+	pdf.File.MimeType = "application/pdf"
+	doc.File.MimeType = "application/msword"
+	if !IsIncluded(rules, &pdf, filteringFolders) {
+		t.Fail()
+	}
+	if IsIncluded(rules, &doc, filteringFolders) {
+		t.Fail()
+	}
+}
+
+func TestAllCriteriaOfARuleMustMatch(t *testing.T) {
+	rule := &Rule{Effect: ExcludeEffect, Actions: []string{"Modified"}, Editors: []string{"bot@acme.com"}}
+	modified, created := aChange("", "acme", drive.Modified), aChange("", "acme", drive.Created)
+	modified.File.LastModifyingUser.EmailAddress = "bot@acme.com"
+	created.File.LastModifyingUser.EmailAddress = "bot@acme.com"
+	if !rule.Matches(&modified, filteringFolders) {
+		t.Fail()
+	}
+	if rule.Matches(&created, filteringFolders) {
+		t.Fail()
+	}
+}
+
+func TestTitlesMatchGlobsAndRegularExpressions(t *testing.T) {
+	glob := &Rule{Effect: ExcludeEffect, Titles: []string{"*.tmp"}}
+	regex := &Rule{Effect: ExcludeEffect, Titles: []string{"/^draft-[0-9]+$/"}}
+	titled := func(title string) *drive.ChangeItem {
+		change := aChange("", "acme", drive.Modified)
+		change.File.Title = title
+		return &change
+	}
+	if !glob.Matches(titled("notes.tmp"), filteringFolders) || glob.Matches(titled("notes.txt"), filteringFolders) {
+		t.Fail()
+	}
+	if !regex.Matches(titled("draft-12"), filteringFolders) || regex.Matches(titled("draft-final"), filteringFolders) {
+		t.Fail()
+	}
+}
+
+func TestEditorsMatchAddressesAndDomains(t *testing.T) {
+	rule := &Rule{Effect: ExcludeEffect, Editors: []string{"@Acme.com", "someone@example.com"}}
+	change := aChange("", "acme", drive.Modified)
+	for _, editor := range []string{"a@acme.com", "SOMEONE@example.com"} {
+		change.File.LastModifyingUser.EmailAddress = editor
+		if !rule.Matches(&change, filteringFolders) {
+			t.Error(editor)
+		}
+	}
+	for _, editor := range []string{"a@notacme.com.evil", "other@example.com", ""} {
+		change.File.LastModifyingUser.EmailAddress = editor
+		if rule.Matches(&change, filteringFolders) {
+			t.Error(editor)
+		}
+	}
+}
+
+func TestFolderPathsMatchTheFolderAndItsAncestors(t *testing.T) {
+	subtree := &Rule{Effect: IncludeEffect, FolderPaths: []string{"/Projects"}}
+	nested := &Rule{Effect: IncludeEffect, FolderPaths: []string{"Projects/*/Reports"}}
+	reports, personal, acme := aChange("", "reports", drive.Modified), aChange("", "personal", drive.Modified), aChange("", "acme", drive.Modified)
+	if !subtree.Matches(&reports, filteringFolders) || subtree.Matches(&personal, filteringFolders) {
+		t.Fail()
+	}
+	if !nested.Matches(&reports, filteringFolders) || nested.Matches(&acme, filteringFolders) {
+		t.Fail()
+	}
+}
+
+func TestFilterChangesKeepsIncludedChangesInOrder(t *testing.T) {
+	rules := []*Rule{{Effect: ExcludeEffect, FolderPaths: []string{"Personal"}}}
+	changes := []drive.ChangeItem{
+		aChange("first", "acme", drive.Modified),
+		aChange("private", "personal", drive.Modified),
+		aChange("second", "reports", drive.Created),
+	}
+	filtered := FilterChanges(rules, changes, filteringFolders)
+	if len(filtered) != 2 || filtered[0].FileId != "first" || filtered[1].FileId != "second" {
+		t.Error(filtered)
+	}
+}
+
+func TestInvalidRulesAreRejected(t *testing.T) {
+	invalid := [][]*Rule{
+		{{Effect: "drop"}},
+		{{Effect: ExcludeEffect, Actions: []string{"Viewed"}}},
+		{{Effect: ExcludeEffect, Titles: []string{"/([/"}}},
+		{{Effect: ExcludeEffect, Titles: []string{"[unclosed"}}},
+		{nil},
+	}
+	for _, rules := range invalid {
+		if ValidateRules(rules) == nil {
+			t.Error(rules)
+		}
+	}
+	if err := ValidateRules([]*Rule{{Effect: IncludeEffect, Titles: []string{"*.pdf", "/x+/"}}}); err != nil {
+		t.Error(err)
+	}
+}
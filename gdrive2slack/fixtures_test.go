@@ -0,0 +1,55 @@
+package gdrive2slack
+
+import (
+	"github.com/optionfactory/gdrive2slack/google"
+	"github.com/optionfactory/gdrive2slack/google/drive"
+	"github.com/optionfactory/gdrive2slack/google/userinfo"
+	"github.com/optionfactory/gdrive2slack/slack"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// anEnvironment talks to fake instead of slack, or to a slack accepting
+// everything when fake is nil, with the metrics, health and request gate that
+// NewEnvironment would set up
+func anEnvironment(fake *fakeSlack) *Environment {
+	if fake == nil {
+		fake = &fakeSlack{responses: []fakeSlackResponse{slackOk}}
+	}
+	env := &Environment{
+		Configuration: &Configuration{Google: &google.OauthConfiguration{RedirectUri: "https://gdrive2slack.example.com"}},
+		Logger:        NewLogger(ioutil.Discard, "", 0),
+		HttpClient:    &http.Client{Transport: fake},
+		SlackThrottle: NewSlackThrottle(),
+		Requests:      NewRequestGate(),
+		Health:        NewHealth(time.Now()),
+	}
+	env.Metrics = NewMetrics(env)
+	return env
+}
+
+// aSubscription posts to #general on behalf of jane, tests change what they
+// are about
+func aSubscription() *Subscription {
+	return &Subscription{
+		Id:                 "s1",
+		Channel:            "#general",
+		SlackAccessToken:   "slack-token",
+		GoogleRefreshToken: "g-refresh-token",
+		GoogleUserInfo:     &userinfo.UserInfo{Email: "user@example.com"},
+		SlackUserInfo:      &slack.UserInfo{User: "jane"},
+	}
+}
+
+// aChange is a change to fileId, a file in folderId
+func aChange(fileId string, folderId string, action drive.Action) drive.ChangeItem {
+	return drive.ChangeItem{
+		FileId:     fileId,
+		LastAction: action,
+		File: drive.ChangedFile{
+			Title:   "a file in " + folderId,
+			Parents: []drive.Parent{{Id: folderId}},
+		},
+	}
+}
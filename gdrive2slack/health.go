@@ -0,0 +1,99 @@
+package gdrive2slack
+
+import (
+	"sync"
+	"time"
+)
+
+// minReadyLoopAge keeps /readyz from failing with short or missing intervals,
+// a loop taking the time to poll every subscription anyway
+const minReadyLoopAge = 5 * time.Minute
+
+// Health is what the event loop tells the http server about its progress, it
+// is read by /readyz
+type Health struct {
+	mutex      sync.Mutex
+	startedAt  time.Time
+	loadedAt   *time.Time
+	lastLoopAt *time.Time
+	storeError error
+}
+
+// Readiness is the body of /readyz
+type Readiness struct {
+	Ready               bool       `json:"ready"`
+	SubscriptionsLoaded bool       `json:"subscriptionsLoaded"`
+	LastLoopAt          *time.Time `json:"lastLoopAt"`
+	LoopOverdue         bool       `json:"loopOverdue"`
+	StoreWritable       bool       `json:"storeWritable"`
+	StoreError          string     `json:"storeError,omitempty"`
+	Stopping            bool       `json:"stopping"`
+}
+
+func NewHealth(now time.Time) *Health {
+	return &Health{startedAt: now}
+}
+
+func (self *Health) SubscriptionsLoaded(now time.Time) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.loadedAt = &now
+}
+
+func (self *Health) LoopCompleted(now time.Time) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.lastLoopAt = &now
+}
+
+// StoreWritten records the outcome of the last write to the store
+func (self *Health) StoreWritten(err error) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.storeError = err
+}
+
+// Readiness tells whether the subscriptions are loaded, stored and served:
+// the loop must complete within maxLoopAge, counted from the load of the
+// subscriptions until the first loop completes
+func (self *Health) Readiness(now time.Time, maxLoopAge time.Duration) *Readiness {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	readiness := &Readiness{
+		SubscriptionsLoaded: self.loadedAt != nil,
+		LastLoopAt:          self.lastLoopAt,
+		StoreWritable:       self.storeError == nil,
+	}
+	if self.storeError != nil {
+		readiness.StoreError = self.storeError.Error()
+	}
+	since := self.lastLoopAt
+	if since == nil {
+		since = self.loadedAt
+	}
+	readiness.LoopOverdue = since != nil && now.Sub(*since) > maxLoopAge
+	readiness.Ready = readiness.SubscriptionsLoaded && !readiness.LoopOverdue && readiness.StoreWritable
+	return readiness
+}
+
+func handleLiveness(env *Environment) map[string]interface{} {
+	return map[string]interface{}{
+		"alive":     true,
+		"version":   env.Version,
+		"startedAt": env.Health.startedAt,
+	}
+}
+
+func handleReadiness(env *Environment) (int, *Readiness) {
+	maxLoopAge := time.Duration(env.Configuration.ReadyLoops*env.Configuration.Interval) * time.Second
+	if maxLoopAge < minReadyLoopAge {
+		maxLoopAge = minReadyLoopAge
+	}
+	readiness := env.Health.Readiness(time.Now(), maxLoopAge)
+	readiness.Stopping = env.IsStopping()
+	readiness.Ready = readiness.Ready && !readiness.Stopping
+	if !readiness.Ready {
+		return 503, readiness
+	}
+	return 200, readiness
+}
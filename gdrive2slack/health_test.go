@@ -0,0 +1,46 @@
+package gdrive2slack
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReadinessRequiresLoadedSubscriptions(t *testing.T) {
+	now := time.Now()
+	health := NewHealth(now)
+	if health.Readiness(now, time.Minute).Ready {
+		t.Error("ready before loading")
+	}
+	health.SubscriptionsLoaded(now)
+	if readiness := health.Readiness(now.Add(30*time.Second), time.Minute); !readiness.Ready || readiness.LastLoopAt != nil {
+		t.Error(readiness)
+	}
+}
+
+func TestStalledLoopsAreNotReady(t *testing.T) {
+	now := time.Now()
+	health := NewHealth(now)
+	health.SubscriptionsLoaded(now)
+	if readiness := health.Readiness(now.Add(2*time.Minute), time.Minute); readiness.Ready || !readiness.LoopOverdue {
+		t.Error("first loop never completed", readiness)
+	}
+	health.LoopCompleted(now.Add(2 * time.Minute))
+	if !health.Readiness(now.Add(2*time.Minute), time.Minute).Ready {
+		t.Error("loop completed")
+	}
+}
+
+func TestUnwritableStoresAreNotReady(t *testing.T) {
+	now := time.Now()
+	health := NewHealth(now)
+	health.SubscriptionsLoaded(now)
+	health.StoreWritten(errors.New("read-only file system"))
+	if readiness := health.Readiness(now, time.Minute); readiness.Ready || readiness.StoreError != "read-only file system" {
+		t.Error(readiness)
+	}
+	health.StoreWritten(nil)
+	if !health.Readiness(now, time.Minute).Ready {
+		t.Fail()
+	}
+}
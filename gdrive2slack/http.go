@@ -5,23 +5,177 @@ import (
 	"github.com/go-martini/martini"
 	"github.com/martini-contrib/render"
 	"github.com/optionfactory/gdrive2slack/google"
+	"github.com/optionfactory/gdrive2slack/google/drive"
 	"github.com/optionfactory/gdrive2slack/google/userinfo"
 	"github.com/optionfactory/gdrive2slack/slack"
+	"io/ioutil"
 	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
 )
 
 type Request struct {
-	GoogleCode string   `json:"g"`
-	SlackCode  string   `json:"s"`
-	Channel    string   `json:"c"`
-	FolderIds  []string `json:"fids"`
-	FolderName string   `json:"fn"`
+	Id          string                    `json:"id"`
+	GoogleCode  string                    `json:"g"`
+	SlackCode   string                    `json:"s"`
+	Channel     string                    `json:"c"`
+	FolderIds   []string                  `json:"fids"`
+	FolderName  string                    `json:"fn"`
+	Routes      []*Route                  `json:"routes"`
+	Rules       []*Rule                   `json:"rules"`
+	Digest      *DigestConfiguration      `json:"digest"`
+	Renderer    string                    `json:"renderer"`
+	Editors     string                    `json:"editors"`
+	Thread      bool                      `json:"thread"`
+	FileThreads *FileThreadsConfiguration `json:"fileThreads"`
+	Update      bool                      `json:"update"`
+}
+
+// reconfigurableSettings are the json names of the settings of a Request, the
+// ones left out when reconfiguring a subscription are kept as they were
+var reconfigurableSettings = []string{"c", "fids", "routes", "rules", "digest", "renderer", "editors", "thread", "fileThreads", "update"}
+
+// keptSettings lists the settings body leaves out when it reconfigures an
+// existing subscription, none for a new subscription
+func (self *Request) keptSettings(body map[string]json.RawMessage) map[string]bool {
+	kept := make(map[string]bool)
+	if self.Id == "" {
+		return kept
+	}
+	for _, setting := range reconfigurableSettings {
+		if _, sent := body[setting]; !sent {
+			kept[setting] = true
+		}
+	}
+	return kept
 }
 
 type ErrResponse struct {
 	Error string `json:"error"`
 }
 
+type SessionRequest struct {
+	GoogleCode string `json:"g"`
+}
+
+// SubscriptionSummary is what the web ui shows of a subscription, tokens excluded
+type SubscriptionSummary struct {
+	Id             string                    `json:"id"`
+	Team           string                    `json:"team"`
+	SlackUser      string                    `json:"slackUser"`
+	Channel        string                    `json:"channel"`
+	FolderIds      []string                  `json:"folderIds"`
+	Folders        []*WatchedFolder          `json:"folders"`
+	Paused         bool                      `json:"paused"`
+	FailingSince   *time.Time                `json:"failingSince"`
+	LastDeliveryAt *time.Time                `json:"lastDeliveryAt"`
+	Routes         []*Route                  `json:"routes"`
+	Rules          []*Rule                   `json:"rules"`
+	Digest         *DigestConfiguration      `json:"digest"`
+	Renderer       string                    `json:"renderer"`
+	Editors        string                    `json:"editors"`
+	Thread         bool                      `json:"thread"`
+	FileThreads    *FileThreadsConfiguration `json:"fileThreads"`
+	Update         bool                      `json:"update"`
+}
+
+// WatchedFolder is a folder of a subscription, Path is empty until resolved
+type WatchedFolder struct {
+	Id   string `json:"id"`
+	Path string `json:"path"`
+}
+
+// SubscriptionChange is what users can change of a subscription without
+// authorizing again, nil fields are left alone
+type SubscriptionChange struct {
+	Channel   *string  `json:"channel"`
+	FolderIds []string `json:"folderIds"`
+	Paused    *bool    `json:"paused"`
+}
+
+// NewSubscriptionSummary must be called by the event loop, the summary is
+// given copies of what workers and commands keep changing so that it can be
+// encoded outside of it
+func NewSubscriptionSummary(sub *Subscription, state *UserState) *SubscriptionSummary {
+	folders := make([]*WatchedFolder, 0, len(sub.GoogleInterestingFolderIds))
+	for _, id := range sub.GoogleInterestingFolderIds {
+		folders = append(folders, &WatchedFolder{Id: id})
+	}
+	summary := &SubscriptionSummary{
+		Id:             sub.Id,
+		Team:           sub.SlackUserInfo.Team,
+		SlackUser:      sub.SlackUserInfo.User,
+		Channel:        sub.Channel,
+		FolderIds:      copyStrings(sub.GoogleInterestingFolderIds),
+		Folders:        folders,
+		Paused:         sub.Paused,
+		FailingSince:   copyTime(state.FailingSince),
+		LastDeliveryAt: copyTime(state.LastDeliveryAt),
+		Routes:         make([]*Route, 0, len(sub.Routes)),
+		Rules:          make([]*Rule, 0, len(sub.Rules)),
+		Renderer:       sub.Renderer,
+		Editors:        sub.EditorMentions,
+		Thread:         sub.ThreadChunks,
+		Update:         sub.UpdateInPlace,
+	}
+	for _, route := range sub.Routes {
+		summary.Routes = append(summary.Routes, &Route{
+			FolderIds: copyStrings(route.FolderIds),
+			Channel:   route.Channel,
+			ChannelId: route.ChannelId,
+			Actions:   copyStrings(route.Actions),
+			MimeTypes: copyStrings(route.MimeTypes),
+		})
+	}
+	for _, rule := range sub.Rules {
+		summary.Rules = append(summary.Rules, &Rule{
+			Effect:      rule.Effect,
+			Actions:     copyStrings(rule.Actions),
+			MimeTypes:   copyStrings(rule.MimeTypes),
+			Titles:      copyStrings(rule.Titles),
+			Editors:     copyStrings(rule.Editors),
+			FolderPaths: copyStrings(rule.FolderPaths),
+		})
+	}
+	if sub.Digest != nil {
+		digest := *sub.Digest
+		summary.Digest = &digest
+	}
+	if sub.FileThreads != nil {
+		fileThreads := *sub.FileThreads
+		summary.FileThreads = &fileThreads
+	}
+	return summary
+}
+
+func copyStrings(values []string) []string {
+	return append(make([]string, 0, len(values)), values...)
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	copied := *t
+	return &copied
+}
+
+type byTeamAndChannel []*SubscriptionSummary
+
+func (self byTeamAndChannel) Len() int      { return len(self) }
+func (self byTeamAndChannel) Swap(i, j int) { self[i], self[j] = self[j], self[i] }
+func (self byTeamAndChannel) Less(i, j int) bool {
+	if self[i].Team != self[j].Team {
+		return self[i].Team < self[j].Team
+	}
+	if self[i].Channel != self[j].Channel {
+		return self[i].Channel < self[j].Channel
+	}
+	return self[i].Id < self[j].Id
+}
+
 func ServeHttp(env *Environment) {
 	r := martini.NewRouter()
 	mr := martini.New()
@@ -31,26 +185,83 @@ func ServeHttp(env *Environment) {
 	}))
 	mr.MapTo(r, (*martini.Routes)(nil))
 	mr.Action(r.Handle)
-	m := &martini.ClassicMartini{mr, r}
+	m := &martini.ClassicMartini{Martini: mr, Router: r}
 	m.Use(render.Renderer())
+	m.Use(func(c martini.Context, renderer render.Render, req *http.Request) {
+		if isProbe(req) {
+			return
+		}
+		if !env.Requests.Enter() {
+			renderer.JSON(503, &ErrResponse{"Shutting down"})
+			return
+		}
+		defer env.Requests.Leave()
+		c.Next()
+	})
 
 	m.Get("/", func(renderer render.Render, req *http.Request) {
 		renderer.HTML(200, "index", env)
 	})
-	m.Put("/", func(renderer render.Render, req *http.Request) {
-		handleSubscriptionRequest(env, renderer, req)
+	m.Put("/", func(renderer render.Render, w http.ResponseWriter, req *http.Request) {
+		handleSubscriptionRequest(env, renderer, w, req)
+	})
+	m.Post("/session", func(renderer render.Render, w http.ResponseWriter, req *http.Request) {
+		handleSessionRequest(env, renderer, w, req)
+	})
+	m.Get("/subscriptions", func(renderer render.Render, req *http.Request) {
+		handleListSubscriptions(env, renderer, req)
+	})
+	m.Patch("/subscriptions/:id", func(renderer render.Render, req *http.Request, params martini.Params) {
+		handleChangeSubscription(env, renderer, req, params["id"])
+	})
+	m.Delete("/subscriptions/:id", func(renderer render.Render, req *http.Request, params martini.Params) {
+		handleDeleteSubscription(env, renderer, req, params["id"])
+	})
+	m.Post("/drive/notifications", func(req *http.Request) (int, string) {
+		return handleDriveNotification(env, req), ""
+	})
+	m.Post("/slack/commands", func(renderer render.Render, req *http.Request) {
+		handleSlashCommand(env, renderer, req)
 	})
-	m.RunOnAddr(env.Configuration.BindAddress)
+	m.Post("/slack/interactions", func(req *http.Request) (int, string) {
+		return handleInteraction(env, req), ""
+	})
+	routeAdminApi(env, m)
+	m.Get("/metrics", env.Metrics.Handler().ServeHTTP)
+	m.Get("/healthz", func(renderer render.Render) {
+		renderer.JSON(200, handleLiveness(env))
+	})
+	m.Get("/readyz", func(renderer render.Render) {
+		renderer.JSON(handleReadiness(env))
+	})
+	server := &http.Server{Addr: env.Configuration.BindAddress, Handler: m}
+	done := make(chan struct{})
+	go stopHttp(env, server, done)
+	env.Logger.Info("listening on %s", server.Addr)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		env.Logger.Error("cannot serve http: %s", err)
+		os.Exit(1)
+	}
+	<-done
 }
 
-func handleSubscriptionRequest(env *Environment, renderer render.Render, req *http.Request) {
-	decoder := json.NewDecoder(req.Body)
-	var r Request
-	err := decoder.Decode(&r)
+func handleSubscriptionRequest(env *Environment, renderer render.Render, w http.ResponseWriter, req *http.Request) {
+	body, err := ioutil.ReadAll(req.Body)
 	if err != nil {
 		renderer.JSON(400, &ErrResponse{err.Error()})
 		return
 	}
+	var r Request
+	var sent map[string]json.RawMessage
+	if err := json.Unmarshal(body, &r); err != nil {
+		renderer.JSON(400, &ErrResponse{err.Error()})
+		return
+	}
+	if err := json.Unmarshal(body, &sent); err != nil {
+		renderer.JSON(400, &ErrResponse{err.Error()})
+		return
+	}
+	kept := r.keptSettings(sent)
 	if r.GoogleCode == "" {
 		renderer.JSON(400, &ErrResponse{"Invalid oauth code for google"})
 		return
@@ -59,9 +270,58 @@ func handleSubscriptionRequest(env *Environment, renderer render.Render, req *ht
 		renderer.JSON(400, &ErrResponse{"Invalid oauth code for slack"})
 		return
 	}
-	if r.Channel == "" {
+	if r.Channel == "" && !kept["c"] {
 		r.Channel = "#general"
 	}
+	if r.Routes == nil {
+		r.Routes = make([]*Route, 0)
+	}
+	if err := ValidateRoutes(r.Routes); err != nil {
+		renderer.JSON(400, &ErrResponse{err.Error()})
+		return
+	}
+	for _, route := range r.Routes {
+		// resolved below
+		route.ChannelId = ""
+	}
+	if r.Rules == nil {
+		r.Rules = make([]*Rule, 0)
+	}
+	if err := ValidateRules(r.Rules); err != nil {
+		renderer.JSON(400, &ErrResponse{err.Error()})
+		return
+	}
+	if r.Digest.IsDigestConfigured() {
+		if err := r.Digest.Validate(); err != nil {
+			renderer.JSON(400, &ErrResponse{err.Error()})
+			return
+		}
+	} else {
+		r.Digest = nil
+	}
+	if r.FileThreads.IsFileThreadsConfigured() {
+		if err := r.FileThreads.Validate(); err != nil {
+			renderer.JSON(400, &ErrResponse{err.Error()})
+			return
+		}
+	} else {
+		r.FileThreads = nil
+	}
+	if r.Update && (r.FileThreads != nil || r.Digest != nil) {
+		renderer.JSON(400, &ErrResponse{"Messages updated in place cannot be combined with file threads or digests"})
+		return
+	}
+	if r.Renderer == "" {
+		r.Renderer = AttachmentsRenderer
+	}
+	if r.Renderer != AttachmentsRenderer && r.Renderer != BlocksRenderer {
+		renderer.JSON(400, &ErrResponse{"Invalid message renderer: " + r.Renderer})
+		return
+	}
+	if r.Editors != "" && r.Editors != PingEditors && r.Editors != NameEditors {
+		renderer.JSON(400, &ErrResponse{"Invalid editors rendering: " + r.Editors})
+		return
+	}
 	googleRefreshToken, googleAccessToken, status, err := google.NewAccessToken(env.Configuration.Google, env.HttpClient, r.GoogleCode)
 	if status != google.Ok {
 		renderer.JSON(500, &ErrResponse{err.Error()})
@@ -83,24 +343,318 @@ func handleSubscriptionRequest(env *Environment, renderer render.Render, req *ht
 		return
 	}
 
-	welcomeMessage := CreateSlackWelcomeMessage(r.Channel, env.Configuration.Google.RedirectUri, sUserInfo, env.Version)
-	cstatus, err := slack.PostMessage(env.HttpClient, slackAccessToken, welcomeMessage)
+	subscription := &Subscription{
+		Id:                         r.Id,
+		Channel:                    r.Channel,
+		SlackAccessToken:           slackAccessToken,
+		GoogleRefreshToken:         googleRefreshToken,
+		GoogleUserInfo:             gUserInfo,
+		SlackUserInfo:              sUserInfo,
+		GoogleInterestingFolderIds: r.FolderIds,
+		Routes:                     r.Routes,
+		Rules:                      r.Rules,
+		Digest:                     r.Digest,
+		Renderer:                   r.Renderer,
+		EditorMentions:             r.Editors,
+		ThreadChunks:               r.Thread,
+		FileThreads:                r.FileThreads,
+		UpdateInPlace:              r.Update,
+	}
+	if _, rstatus, err := ResolveChannels(env.HttpClient, subscription); rstatus != slack.Ok {
+		env.Logger.Warning("[%s/%s] while resolving channels: %s", gUserInfo.Email, sUserInfo.User, err)
+	}
+
+	// a kept channel is already welcomed
+	channelFound := true
+	welcomed := make(map[string]bool)
+	if !kept["c"] {
+		target := channelTarget(subscription.Channel, subscription.ChannelId)
+		posted, cstatus, _ := slack.PostMessage(env.HttpClient, slackAccessToken, CreateSlackWelcomeMessage(target, env.Configuration.Google.RedirectUri, sUserInfo, env.Version))
+		if cstatus == slack.Ok {
+			subscription.learnChannelId(target, posted.Channel)
+		}
+		channelFound = cstatus == slack.Ok
+		welcomed[target] = true
+	}
+	for _, route := range subscription.Routes {
+		target := channelTarget(route.Channel, route.ChannelId)
+		if welcomed[target] {
+			continue
+		}
+		welcomed[target] = true
+		if posted, status, _ := slack.PostMessage(env.HttpClient, slackAccessToken, CreateSlackWelcomeMessage(target, env.Configuration.Google.RedirectUri, sUserInfo, env.Version)); status == slack.Ok {
+			subscription.learnChannelId(target, posted.Channel)
+		}
+	}
 
 	env.RegisterChannel <- &SubscriptionAndAccessToken{
-		Subscription: &Subscription{
-			r.Channel,
-			slackAccessToken,
-			googleRefreshToken,
-			gUserInfo,
-			sUserInfo,
-			r.FolderIds,
-		},
+		Subscription:      subscription,
 		GoogleAccessToken: googleAccessToken,
+		KeptSettings:      kept,
 	}
 
+	http.SetCookie(w, newSessionCookie(env.SessionKey, gUserInfo.Email, time.Now()))
 	renderer.JSON(200, map[string]interface{}{
 		"user":         gUserInfo,
-		"channelFound": cstatus == slack.Ok,
+		"channelFound": channelFound,
+	})
+
+}
+
+// handleSessionRequest signs in a google user to manage existing subscriptions
+func handleSessionRequest(env *Environment, renderer render.Render, w http.ResponseWriter, req *http.Request) {
+	var r SessionRequest
+	if err := json.NewDecoder(req.Body).Decode(&r); err != nil {
+		renderer.JSON(400, &ErrResponse{err.Error()})
+		return
+	}
+	if r.GoogleCode == "" {
+		renderer.JSON(400, &ErrResponse{"Invalid oauth code for google"})
+		return
+	}
+	_, googleAccessToken, status, err := google.NewAccessToken(env.Configuration.Google, env.HttpClient, r.GoogleCode)
+	if status != google.Ok {
+		renderer.JSON(500, &ErrResponse{err.Error()})
+		return
+	}
+	gUserInfo, status, err := userinfo.GetUserInfo(env.HttpClient, googleAccessToken)
+	if status != google.Ok {
+		renderer.JSON(500, &ErrResponse{err.Error()})
+		return
+	}
+	http.SetCookie(w, newSessionCookie(env.SessionKey, gUserInfo.Email, time.Now()))
+	renderer.JSON(200, map[string]interface{}{
+		"user": gUserInfo,
+	})
+}
+
+func handleListSubscriptions(env *Environment, renderer render.Render, req *http.Request) {
+	email, ok := sessionEmail(env.SessionKey, req, time.Now())
+	if !ok {
+		renderer.JSON(401, &ErrResponse{"Not signed in"})
+		return
+	}
+	type listing struct {
+		summaries []*SubscriptionSummary
+		lookups   []*folderLookup
+	}
+	result := make(chan listing, 1)
+	env.CommandChannel <- func(subscriptions *Subscriptions) {
+		l := listing{make([]*SubscriptionSummary, 0), make([]*folderLookup, 0)}
+		for _, sub := range subscriptions.FindByEmail(email) {
+			state := subscriptions.States[sub.Id]
+			summary := NewSubscriptionSummary(sub, state)
+			l.summaries = append(l.summaries, summary)
+			if len(summary.Folders) != 0 {
+				l.lookups = append(l.lookups, &folderLookup{
+					id:           sub.Id,
+					email:        sub.GoogleUserInfo.Email,
+					slackUser:    sub.SlackUserInfo.User,
+					refreshToken: sub.GoogleRefreshToken,
+					accessToken:  state.GoogleAccessToken,
+					folders:      summary.Folders,
+				})
+			}
+		}
+		result <- l
+	}
+	l := <-result
+	resolveFolderPaths(env, l.lookups)
+	sort.Sort(byTeamAndChannel(l.summaries))
+	renderer.JSON(200, l.summaries)
+}
+
+// folderLookup is what resolving the folder paths of a subscription needs,
+// copied by the event loop so that it can be done outside of it
+type folderLookup struct {
+	id           string
+	email        string
+	slackUser    string
+	refreshToken string
+	accessToken  string
+	folders      []*WatchedFolder
+}
+
+// resolveFolderPaths fills in the paths of the watched folders, the folders no
+// longer found are left without a path. Each google account is looked up once,
+// the accounts in parallel; the refreshed access tokens are handed back to the
+// event loop.
+func resolveFolderPaths(env *Environment, lookups []*folderLookup) {
+	byAccount := make(map[string][]*folderLookup)
+	for _, lookup := range lookups {
+		byAccount[lookup.refreshToken] = append(byAccount[lookup.refreshToken], lookup)
+	}
+	refreshed := make(chan map[string]string, len(byAccount))
+	for _, account := range byAccount {
+		go func(account []*folderLookup) {
+			refreshed <- resolveAccountFolderPaths(env, account)
+		}(account)
+	}
+	tokens := make(map[string]string)
+	for range byAccount {
+		for id, accessToken := range <-refreshed {
+			tokens[id] = accessToken
+		}
+	}
+	if len(tokens) == 0 {
+		return
+	}
+	env.CommandChannel <- func(subscriptions *Subscriptions) {
+		for _, lookup := range lookups {
+			accessToken, found := tokens[lookup.id]
+			if !found || !subscriptions.Contains(lookup.id) || subscriptions.Info[lookup.id].GoogleRefreshToken != lookup.refreshToken {
+				continue
+			}
+			subscriptions.States[lookup.id].GoogleAccessToken = accessToken
+		}
+	}
+}
+
+// resolveAccountFolderPaths looks up the folders of a google account shared by
+// lookups, returning the access tokens refreshed meanwhile by subscription id
+func resolveAccountFolderPaths(env *Environment, lookups []*folderLookup) (refreshed map[string]string) {
+	first := lookups[0]
+	defer func() {
+		if r := recover(); r != nil {
+			env.Logger.Warning("[%s/%s] cannot resolve folder paths: %v", first.email, first.slackUser, r)
+		}
+	}()
+	var folders *drive.Folders
+	accessToken, err := google.DoWithAccessToken(env.Configuration.Google, env.HttpClient, first.refreshToken, first.accessToken, func(at string) (google.StatusCode, error) {
+		var status google.StatusCode
+		var err error
+		started := time.Now()
+		status, err, folders = drive.FetchFolders(env.HttpClient, at)
+		env.Metrics.ObserveDrive("folders", started, status)
+		return status, err
 	})
+	if err != nil {
+		env.Logger.Warning("[%s/%s] cannot resolve folder paths: %s", first.email, first.slackUser, err)
+		return nil
+	}
+	refreshed = make(map[string]string)
+	for _, lookup := range lookups {
+		for _, folder := range lookup.folders {
+			folder.Path, _ = folders.FullPathFor(folder.Id)
+		}
+		if lookup.accessToken != accessToken {
+			refreshed[lookup.id] = accessToken
+		}
+	}
+	return refreshed
+}
+
+// changeSubscription applies a change made from the web ui to the subscription
+// with the given id when it belongs to email, it must be run by the event loop
+func changeSubscription(env *Environment, subscriptions *Subscriptions, id string, email string, change *SubscriptionChange) (*SubscriptionSummary, bool, error) {
+	subscription, found := subscriptions.Info[id]
+	if !found || subscription.GoogleUserInfo.Email != email {
+		return nil, false, nil
+	}
+	state := subscriptions.States[id]
+	if change.Channel != nil {
+		subscription.Channel, subscription.ChannelId, _ = channelOf(*change.Channel)
+		// resolved by the next poll
+		state.ChannelsCheckedAt = nil
+	}
+	if change.FolderIds != nil {
+		subscription.GoogleInterestingFolderIds = change.FolderIds
+	}
+	if change.Paused != nil {
+		subscriptions.SetPaused(id, *change.Paused)
+	}
+	env.Logger.Info("[%s/%s] *subscription %s: changed by user", email, subscription.SlackUserInfo.User, id)
+	return NewSubscriptionSummary(subscription, state), true, subscriptions.Save(id)
+}
+
+func handleChangeSubscription(env *Environment, renderer render.Render, req *http.Request, id string) {
+	email, ok := sessionEmail(env.SessionKey, req, time.Now())
+	if !ok {
+		renderer.JSON(401, &ErrResponse{"Not signed in"})
+		return
+	}
+	var change SubscriptionChange
+	if err := json.NewDecoder(req.Body).Decode(&change); err != nil {
+		renderer.JSON(400, &ErrResponse{err.Error()})
+		return
+	}
+	if change.Channel != nil {
+		if _, _, valid := channelOf(*change.Channel); !valid {
+			renderer.JSON(400, &ErrResponse{"Invalid channel, use #channel or @user"})
+			return
+		}
+	}
+	if change.FolderIds != nil {
+		folderIds := make([]string, 0, len(change.FolderIds))
+		for _, folderId := range change.FolderIds {
+			if folderId = folderIdOf(strings.TrimSpace(folderId)); folderId != "" && !containsString(folderIds, folderId) {
+				folderIds = append(folderIds, folderId)
+			}
+		}
+		change.FolderIds = folderIds
+	}
+	type outcome struct {
+		summary *SubscriptionSummary
+		found   bool
+		err     error
+	}
+	result := make(chan outcome, 1)
+	env.CommandChannel <- func(subscriptions *Subscriptions) {
+		summary, found, err := changeSubscription(env, subscriptions, id, email, &change)
+		result <- outcome{summary, found, err}
+	}
+	o := <-result
+	if !o.found {
+		renderer.JSON(404, &ErrResponse{"Subscription not found"})
+		return
+	}
+	if o.err != nil {
+		renderer.JSON(500, &ErrResponse{o.err.Error()})
+		return
+	}
+	renderer.JSON(200, o.summary)
+}
 
+// unsubscribe removes a subscription on behalf of its user or of an admin, as
+// told by by, it must be run by the event loop
+func unsubscribe(env *Environment, subscriptions *Subscriptions, id string, email string, by string) (bool, error) {
+	subscription, state, found, err := subscriptions.Remove(id, email)
+	if !found {
+		return false, nil
+	}
+	env.Logger.Info("[%s/%s] -subscription %s: removed by %s", email, subscription.SlackUserInfo.User, id, by)
+	stopWatchChannel(env, state.GoogleAccessToken, state.Watch)
+	if !subscriptions.ContainsEmail(email) {
+		go mailchimpDeregistrationTask(env, subscription)
+	}
+	return true, err
+}
+
+func handleDeleteSubscription(env *Environment, renderer render.Render, req *http.Request, id string) {
+	email, ok := sessionEmail(env.SessionKey, req, time.Now())
+	if !ok {
+		renderer.JSON(401, &ErrResponse{"Not signed in"})
+		return
+	}
+	type removal struct {
+		found bool
+		err   error
+	}
+	result := make(chan removal, 1)
+	env.CommandChannel <- func(subscriptions *Subscriptions) {
+		found, err := unsubscribe(env, subscriptions, id, email, "user")
+		result <- removal{found, err}
+	}
+	r := <-result
+	if !r.found {
+		renderer.JSON(404, &ErrResponse{"Subscription not found"})
+		return
+	}
+	if r.err != nil {
+		renderer.JSON(500, &ErrResponse{r.err.Error()})
+		return
+	}
+	renderer.JSON(200, map[string]interface{}{
+		"id": id,
+	})
 }
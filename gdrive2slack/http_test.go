@@ -0,0 +1,110 @@
+package gdrive2slack
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSummariesShowTheDeliveryStatus(t *testing.T) {
+	defer cleanup(t, "/tmp", "temp-subs*")
+	subs := commandSubscriptions("#general")
+	id := subs.Keys()[0]
+	now := time.Now()
+	subs.States[id].LastDeliveryAt = &now
+	subs.States[id].FailingSince = &now
+	subs.Info[id].GoogleInterestingFolderIds = []string{"mockups"}
+	summary := NewSubscriptionSummary(subs.Info[id], subs.States[id])
+	if !summary.LastDeliveryAt.Equal(now) || !summary.FailingSince.Equal(now) || len(summary.Folders) != 1 || summary.Folders[0].Id != "mockups" {
+		t.Error(summary)
+	}
+}
+
+func TestDeliveriesAreRecorded(t *testing.T) {
+	state := queued("#general")
+	deliverOutbox(anEnvironment(&fakeSlack{responses: []fakeSlackResponse{slackOk}}), aSubscription(), state)
+	if state.LastDeliveryAt == nil {
+		t.Fail()
+	}
+	failed := queued("#general")
+	deliverOutbox(anEnvironment(&fakeSlack{responses: []fakeSlackResponse{slackArchived}}), aSubscription(), failed)
+	if failed.LastDeliveryAt != nil {
+		t.Fail()
+	}
+}
+
+func TestUsersCanChangeTheirSubscriptionsWithoutSubscribingAgain(t *testing.T) {
+	defer cleanup(t, "/tmp", "temp-subs*")
+	subs := commandSubscriptions("#general")
+	id := subs.Keys()[0]
+	subs.Info[id].ChannelId = "C1"
+	channel := "#design"
+	paused := true
+	change := &SubscriptionChange{Channel: &channel, FolderIds: []string{"0B1abc"}, Paused: &paused}
+	if _, found, _ := changeSubscription(anEnvironment(nil), subs, id, "other@example.com", change); found {
+		t.Error("changed by another account")
+	}
+	summary, found, err := changeSubscription(anEnvironment(nil), subs, id, "user@example.com", change)
+	if !found || err != nil || summary.Channel != "#design" || !summary.Paused || summary.Folders[0].Id != "0B1abc" {
+		t.Fatal(summary, err)
+	}
+	reloaded, _ := LoadSubscriptions(NewJsonStore("/tmp/temp-subs"))
+	if sub := reloaded.Info[id]; sub.ChannelId != "" || sub.GoogleInterestingFolderIds[0] != "0B1abc" || !sub.Paused {
+		t.Error(sub)
+	}
+}
+
+// drivingFolders serves a drive with a single folder, counting the listings
+type drivingFolders struct {
+	listings int32
+}
+
+func (self *drivingFolders) RoundTrip(req *http.Request) (*http.Response, error) {
+	body := `{"drives":[]}`
+	if !strings.Contains(req.URL.Path, "/drives") {
+		atomic.AddInt32(&self.listings, 1)
+		body = `{"files":[{"id":"mockups","name":"Mockups","parents":[]}]}`
+	}
+	return &http.Response{StatusCode: 200, Header: make(http.Header), Body: ioutil.NopCloser(strings.NewReader(body))}, nil
+}
+
+func TestFolderPathsAreResolvedOncePerAccount(t *testing.T) {
+	transport := &drivingFolders{}
+	env := anEnvironment(nil)
+	env.HttpClient = &http.Client{Transport: transport}
+	env.CommandChannel = make(chan func(*Subscriptions), 1)
+	lookups := []*folderLookup{
+		{id: "1", refreshToken: "refresh", accessToken: "access", folders: []*WatchedFolder{{Id: "mockups"}}},
+		{id: "2", refreshToken: "refresh", accessToken: "access", folders: []*WatchedFolder{{Id: "mockups"}, {Id: "gone"}}},
+	}
+	resolveFolderPaths(env, lookups)
+	if transport.listings != 1 {
+		t.Error(transport.listings)
+	}
+	if lookups[0].folders[0].Path != "Mockups" || lookups[1].folders[0].Path != "Mockups" || lookups[1].folders[1].Path != "" {
+		t.Error(lookups[1].folders)
+	}
+	if len(env.CommandChannel) != 0 {
+		t.Error("unchanged access tokens handed back")
+	}
+}
+
+func TestSummariesDoNotShareTheSubscription(t *testing.T) {
+	defer cleanup(t, "/tmp", "temp-subs*")
+	subs := commandSubscriptions("#general")
+	id := subs.Keys()[0]
+	sub := subs.Info[id]
+	sub.GoogleInterestingFolderIds = []string{"mockups"}
+	sub.Routes = []*Route{{FolderIds: []string{"design"}, Channel: "#design"}}
+	sub.Digest = &DigestConfiguration{Frequency: "daily"}
+	summary := NewSubscriptionSummary(sub, subs.States[id])
+	sub.GoogleInterestingFolderIds[0] = "specs"
+	sub.Routes[0].ChannelId = "C1"
+	sub.Digest.Hour = 9
+	if summary.FolderIds[0] != "mockups" || summary.Routes[0].ChannelId != "" || summary.Digest.Hour != 0 {
+		t.Error(summary)
+	}
+}
@@ -0,0 +1,175 @@
+package gdrive2slack
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// JsonStore keeps subscriptions in a single json file, rewritten atomically
+// on every change, and user states in a sibling file. Like the bolt store, it
+// keeps them encoded: what it is given and what it hands out is never shared.
+type JsonStore struct {
+	Source string
+	info   map[string]json.RawMessage
+	states map[string]json.RawMessage
+}
+
+func NewJsonStore(filename string) *JsonStore {
+	return &JsonStore{
+		Source: filename,
+		info:   make(map[string]json.RawMessage),
+		states: make(map[string]json.RawMessage),
+	}
+}
+
+// Load reads the subscriptions, a missing file being an empty store. Any
+// other error is returned: starting empty would overwrite the file with the
+// next subscription.
+func (self *JsonStore) Load() (map[string]*Subscription, map[string]*UserState, error) {
+	self.info = make(map[string]json.RawMessage)
+	self.states = make(map[string]json.RawMessage)
+	file, err := os.Open(self.Source)
+	if os.IsNotExist(err) {
+		return self.decoded()
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	err = json.NewDecoder(file).Decode(&self.info)
+	if err != nil {
+		return nil, nil, err
+	}
+	states, err := os.Open(self.statesSource())
+	if os.IsNotExist(err) {
+		// states are missing when upgrading from versions that did not persist them
+		return self.decoded()
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer states.Close()
+	err = json.NewDecoder(states).Decode(&self.states)
+	if err != nil {
+		return nil, nil, err
+	}
+	return self.decoded()
+}
+
+func (self *JsonStore) Upsert(key string, subscription *Subscription, state *UserState) error {
+	if err := self.encode(self.info, key, subscription); err != nil {
+		return err
+	}
+	if err := self.encode(self.states, key, state); err != nil {
+		return err
+	}
+	if err := self.saveInfo(); err != nil {
+		return err
+	}
+	return self.saveStates()
+}
+
+func (self *JsonStore) UpsertAll(info map[string]*Subscription, states map[string]*UserState) error {
+	for k, subscription := range info {
+		if err := self.encode(self.info, k, subscription); err != nil {
+			return err
+		}
+		if state, ok := states[k]; ok {
+			if err := self.encode(self.states, k, state); err != nil {
+				return err
+			}
+		}
+	}
+	if err := self.saveInfo(); err != nil {
+		return err
+	}
+	return self.saveStates()
+}
+
+func (self *JsonStore) Delete(keys ...string) error {
+	for _, key := range keys {
+		delete(self.info, key)
+		delete(self.states, key)
+	}
+	if err := self.saveInfo(); err != nil {
+		return err
+	}
+	return self.saveStates()
+}
+
+func (self *JsonStore) UpdateStates(states map[string]*UserState) error {
+	for k, state := range states {
+		if _, ok := self.info[k]; ok {
+			if err := self.encode(self.states, k, state); err != nil {
+				return err
+			}
+		}
+	}
+	return self.saveStates()
+}
+
+func (self *JsonStore) Close() error {
+	return nil
+}
+
+func (self *JsonStore) encode(into map[string]json.RawMessage, key string, value interface{}) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	into[key] = encoded
+	return nil
+}
+
+func (self *JsonStore) decoded() (map[string]*Subscription, map[string]*UserState, error) {
+	info := make(map[string]*Subscription, len(self.info))
+	for k, encoded := range self.info {
+		var subscription *Subscription
+		if err := json.Unmarshal(encoded, &subscription); err != nil {
+			return nil, nil, err
+		}
+		info[k] = subscription
+	}
+	states := make(map[string]*UserState, len(self.states))
+	for k, encoded := range self.states {
+		var state *UserState
+		if err := json.Unmarshal(encoded, &state); err != nil {
+			return nil, nil, err
+		}
+		states[k] = state
+	}
+	return info, states, nil
+}
+
+func (self *JsonStore) statesSource() string {
+	return strings.TrimSuffix(self.Source, ".json") + "-states.json"
+}
+
+func (self *JsonStore) saveInfo() error {
+	return writeJsonAtomically(self.Source, self.info)
+}
+
+func (self *JsonStore) saveStates() error {
+	return writeJsonAtomically(self.statesSource(), self.states)
+}
+
+// the file is replaced atomically so that a crash while writing never
+// leaves a truncated file behind.
+func writeJsonAtomically(filename string, v interface{}) error {
+	file, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(file).Encode(v)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return os.Rename(file.Name(), filename)
+}
@@ -1,10 +1,12 @@
 package gdrive2slack
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/optionfactory/gdrive2slack/google/drive"
 	"github.com/optionfactory/gdrive2slack/slack"
 	"strings"
+	"time"
 	"unicode/utf8"
 )
 
@@ -33,15 +35,73 @@ func preventNotification(source string) string {
 	return strings.Join(split, " ")
 }
 
-func CreateSlackAttachment(change *drive.ChangeItem) *slack.Attachment {
-	var editor string
+const (
+	AttachmentsRenderer = "attachments"
+	BlocksRenderer      = "blocks"
+)
+
+var mimeTypeIcons = map[string]string{
+	"application/vnd.google-apps.folder":       ":file_folder:",
+	"application/vnd.google-apps.document":     ":memo:",
+	"application/vnd.google-apps.spreadsheet":  ":bar_chart:",
+	"application/vnd.google-apps.presentation": ":film_projector:",
+	"application/vnd.google-apps.form":         ":ballot_box_with_check:",
+	"application/vnd.google-apps.drawing":      ":art:",
+	"application/pdf":                          ":closed_book:",
+}
+
+var mimeTypePrefixIcons = map[string]string{
+	"image/": ":frame_with_picture:",
+	"video/": ":movie_camera:",
+	"audio/": ":musical_note:",
+}
+
+func iconFor(mimeType string) string {
+	if icon, ok := mimeTypeIcons[mimeType]; ok {
+		return icon
+	}
+	for prefix, icon := range mimeTypePrefixIcons {
+		if strings.HasPrefix(mimeType, prefix) {
+			return icon
+		}
+	}
+	return ":page_facing_up:"
+}
+
+func formatEditor(change *drive.ChangeItem, editors map[string]string) string {
+	if rendered, resolved := editors[strings.ToLower(change.File.LastModifyingUser.EmailAddress)]; resolved {
+		return rendered
+	}
 	if len(change.File.LastModifyingUser.EmailAddress) > 0 && len(change.File.LastModifyingUser.DisplayName) > 0 {
-		editor = fmt.Sprintf("<mailto:%s|%s>", change.File.LastModifyingUser.EmailAddress, preventNotification(change.File.LastModifyingUser.DisplayName))
-	} else if len(change.File.LastModifyingUser.DisplayName) > 0 {
-		editor = preventNotification(change.File.LastModifyingUser.DisplayName)
-	} else {
-		editor = "Unknown"
+		return fmt.Sprintf("<mailto:%s|%s>", change.File.LastModifyingUser.EmailAddress, preventNotification(change.File.LastModifyingUser.DisplayName))
+	}
+	if len(change.File.LastModifyingUser.DisplayName) > 0 {
+		return preventNotification(change.File.LastModifyingUser.DisplayName)
+	}
+	return "Unknown"
+}
+
+// editorOf is the editor name as plain text, suitable for grouping
+func editorOf(change *drive.ChangeItem) string {
+	if change.File.LastModifyingUser.DisplayName != "" {
+		return preventNotification(change.File.LastModifyingUser.DisplayName)
+	}
+	if change.File.LastModifyingUser.EmailAddress != "" {
+		return change.File.LastModifyingUser.EmailAddress
 	}
+	return "Unknown"
+}
+
+func folderOf(change *drive.ChangeItem, folders *drive.Folders) string {
+	for _, parent := range change.File.Parents {
+		if fullPath, found := folders.FullPathFor(parent.Id); found {
+			return fullPath
+		}
+	}
+	return "/"
+}
+
+func CreateSlackAttachment(change *drive.ChangeItem, editors map[string]string) *slack.Attachment {
 	return &slack.Attachment{
 		Fallback: fmt.Sprintf("Changes Detected to %s <%s|%s>", change.Type, change.File.AlternateLink, change.File.Title),
 		Color:    actionColors[change.LastAction],
@@ -53,29 +113,227 @@ func CreateSlackAttachment(change *drive.ChangeItem) *slack.Attachment {
 			},
 			{
 				Title: "Editor",
-				Value: editor,
+				Value: formatEditor(change, editors),
 				Short: true,
 			},
 		},
 	}
 }
 
-func CreateSlackMessage(subscription *Subscription, userState *UserState, folders *drive.Folders, version string) *slack.Message {
-	var attachments = make([]slack.Attachment, 0, len(userState.Gdrive.ChangeSet))
-	var roots = subscription.GoogleInterestingFolderIds
-	for i := 0; i != len(userState.Gdrive.ChangeSet); i++ {
-		if len(roots) == 0 || folders.FolderIsOrIsContainedInAny(userState.Gdrive.ChangeSet[i].File.Parents, roots) {
-			attachments = append(attachments, *CreateSlackAttachment(&userState.Gdrive.ChangeSet[i]))
+// CreateSlackBlocks renders a change as a section with the file and the
+// action followed by a context with the editor and the folder path.
+func CreateSlackBlocks(change *drive.ChangeItem, folders *drive.Folders, editors map[string]string) []slack.Block {
+	return []slack.Block{
+		slack.NewSectionBlock(fmt.Sprintf("%s *<%s|%s>*\n%s %s", iconFor(change.File.MimeType), change.File.AlternateLink, change.File.Title, change.LastAction, change.Type)),
+		slack.NewContextBlock(
+			fmt.Sprintf("Editor: %s", formatEditor(change, editors)),
+			fmt.Sprintf("Folder: %s", folderOf(change, folders)),
+		),
+	}
+}
+
+// Slack rejects messages with too many attachments or blocks or too long
+// with msg_too_long, larger contents are split in several messages.
+const (
+	maxAttachmentsPerMessage = 20
+	// one block is kept for the notice about an unknown channel
+	maxBlocksPerMessage = slack.MaxBlocks - 1
+	maxMessageSize      = 30000
+)
+
+func jsonSize(v interface{}) int {
+	serialized, _ := json.Marshal(v)
+	return len(serialized)
+}
+
+// chunkAttachments splits the attachments in chunks fitting a message
+func chunkAttachments(attachments []slack.Attachment) [][]slack.Attachment {
+	chunks := make([][]slack.Attachment, 0)
+	current := make([]slack.Attachment, 0)
+	size := 0
+	for _, attachment := range attachments {
+		attachmentSize := jsonSize(attachment)
+		if len(current) != 0 && (len(current) == maxAttachmentsPerMessage || size+attachmentSize > maxMessageSize) {
+			chunks = append(chunks, current)
+			current = make([]slack.Attachment, 0)
+			size = 0
 		}
+		current = append(current, attachment)
+		size += attachmentSize
+	}
+	return append(chunks, current)
+}
 
+// chunkBlocks splits the groups of blocks in chunks fitting a message, the
+// first chunk starting with the header. Groups are never split.
+func chunkBlocks(header []slack.Block, groups [][]slack.Block) [][]slack.Block {
+	chunks := make([][]slack.Block, 0)
+	current := append([]slack.Block{}, header...)
+	size := jsonSize(header)
+	for _, group := range groups {
+		groupSize := jsonSize(group)
+		if len(current) != 0 && (len(current)+len(group) > maxBlocksPerMessage || size+groupSize > maxMessageSize) {
+			chunks = append(chunks, current)
+			current = make([]slack.Block, 0)
+			size = 0
+		}
+		current = append(current, group...)
+		size += groupSize
 	}
-	return &slack.Message{
-		Channel:     subscription.Channel,
-		Username:    "Google Drive",
-		Text:        fmt.Sprintf("Activity on gdrive (configured by @%s)", preventNotification(subscription.SlackUserInfo.User)),
-		IconUrl:     fmt.Sprintf("http://gdrive2slack.optionfactory.net/gdrive2slack.png?ck=%s", version),
-		Attachments: attachments,
+	return append(chunks, current)
+}
+
+func changeBlockGroups(changes []*drive.ChangeItem, folders *drive.Folders, editors map[string]string) [][]slack.Block {
+	groups := make([][]slack.Block, 0, len(changes))
+	for _, change := range changes {
+		groups = append(groups, CreateSlackBlocks(change, folders, editors))
+	}
+	return groups
+}
+
+func changeAttachments(changes []*drive.ChangeItem, editors map[string]string) []slack.Attachment {
+	attachments := make([]slack.Attachment, 0, len(changes))
+	for _, change := range changes {
+		attachments = append(attachments, *CreateSlackAttachment(change, editors))
+	}
+	return attachments
+}
+
+// splitMessage yields a message for each chunk of attachments or blocks,
+// numbering them when more than one is needed. Messages for the same channel
+// are always consecutive.
+func splitMessage(prototype slack.Message, attachmentChunks [][]slack.Attachment, blockChunks [][]slack.Block) []*slack.Message {
+	count := len(attachmentChunks)
+	if blockChunks != nil {
+		count = len(blockChunks)
+	}
+	messages := make([]*slack.Message, 0, count)
+	for i := 0; i != count; i++ {
+		message := prototype
+		if count > 1 {
+			message.Text = fmt.Sprintf("%s (%d/%d)", prototype.Text, i+1, count)
+		}
+		if blockChunks != nil {
+			message.Blocks = blockChunks[i]
+		} else {
+			message.Attachments = attachmentChunks[i]
+		}
+		messages = append(messages, &message)
+	}
+	return messages
+}
+
+// activityPrototype is the message, without changes, notifying the activity to channel
+func activityPrototype(subscription *Subscription, userState *UserState, channel string, version string) slack.Message {
+	text := fmt.Sprintf("Activity on gdrive (configured by @%s)", preventNotification(subscription.SlackUserInfo.User))
+	if userState.Gdrive.Omitted > 0 {
+		text = fmt.Sprintf("%s\nup to %d more changes omitted", text, userState.Gdrive.Omitted)
+	}
+	return slack.Message{
+		Channel:  channel,
+		Username: "Google Drive",
+		Text:     text,
+		IconUrl:  fmt.Sprintf("http://gdrive2slack.optionfactory.net/gdrive2slack.png?ck=%s", version),
+	}
+}
+
+// fileIdsByChunk yields the ids of the files in each chunk, given the number
+// of items (attachments or blocks) rendering each change and the number of
+// items leading the first chunk.
+func fileIdsByChunk(changes []*drive.ChangeItem, itemsPerChange []int, leading int, chunkLengths []int) [][]string {
+	fileIds := make([][]string, 0, len(chunkLengths))
+	next := 0
+	for i, length := range chunkLengths {
+		if i == 0 {
+			length -= leading
+		}
+		ids := make([]string, 0)
+		for ; length > 0 && next != len(changes); next++ {
+			ids = append(ids, changes[next].FileId)
+			length -= itemsPerChange[next]
+		}
+		fileIds = append(fileIds, ids)
 	}
+	return fileIds
+}
+
+// createFreshMessages renders changes to files without a thread to reply to
+func createFreshMessages(subscription *Subscription, prototype slack.Message, changes []*drive.ChangeItem, folders *drive.Folders, editors map[string]string) ([]*slack.Message, [][]string) {
+	itemsPerChange := make([]int, 0, len(changes))
+	chunkLengths := make([]int, 0)
+	var messages []*slack.Message
+	leading := 0
+	if subscription.Renderer == BlocksRenderer {
+		header := []slack.Block{slack.NewSectionBlock(prototype.Text)}
+		groups := changeBlockGroups(changes, folders, editors)
+		for i := range groups {
+			groups[i] = withMuteActions(subscription, changes[i], groups[i])
+			itemsPerChange = append(itemsPerChange, len(groups[i]))
+		}
+		chunks := chunkBlocks(header, groups)
+		for _, chunk := range chunks {
+			chunkLengths = append(chunkLengths, len(chunk))
+		}
+		leading = len(header)
+		messages = splitMessage(prototype, nil, chunks)
+	} else {
+		chunks := chunkAttachments(changeAttachments(changes, editors))
+		for _, chunk := range chunks {
+			chunkLengths = append(chunkLengths, len(chunk))
+		}
+		for range changes {
+			itemsPerChange = append(itemsPerChange, 1)
+		}
+		messages = splitMessage(prototype, chunks, nil)
+	}
+	return messages, fileIdsByChunk(changes, itemsPerChange, leading, chunkLengths)
+}
+
+// createFollowUpMessage renders a change as a reply in the thread of the file
+func createFollowUpMessage(subscription *Subscription, prototype slack.Message, change *drive.ChangeItem, threadTs string, folders *drive.Folders, editors map[string]string) *slack.Message {
+	message := prototype
+	message.ThreadTs = threadTs
+	message.ReplyBroadcast = subscription.FileThreads.BroadcastDeletes && change.LastAction == drive.Deleted
+	if subscription.Renderer == BlocksRenderer {
+		message.Blocks = withMuteActions(subscription, change, CreateSlackBlocks(change, folders, editors))
+	} else {
+		message.Attachments = []slack.Attachment{*CreateSlackAttachment(change, editors)}
+	}
+	return &message
+}
+
+// CreateSlackMessages yields the messages for every channel routed to by the
+// change set, along with the ids of the files notified by each message.
+// Changes to files notified in a channel within the window of the file threads
+// are replies to the earlier notification, following the other messages.
+func CreateSlackMessages(subscription *Subscription, userState *UserState, folders *drive.Folders, editors map[string]string, version string, now time.Time) ([]*slack.Message, [][]string) {
+	changes := UnmutedChanges(subscription.Mutes, FilterChanges(subscription.Rules, userState.Gdrive.ChangeSet, folders), folders, now)
+	channels, routed := RouteChanges(subscription, changes, folders)
+	messages := make([]*slack.Message, 0, len(channels))
+	fileIds := make([][]string, 0, len(channels))
+	for _, channel := range channels {
+		prototype := activityPrototype(subscription, userState, channel, version)
+		fresh := make([]*drive.ChangeItem, 0)
+		followUps := make([]*slack.Message, 0)
+		followUpFileIds := make([][]string, 0)
+		for _, change := range routed[channel] {
+			threadTs, found := userState.fileThreadFor(subscription.FileThreads, channel, change.FileId, now)
+			if !found {
+				fresh = append(fresh, change)
+				continue
+			}
+			followUps = append(followUps, createFollowUpMessage(subscription, prototype, change, threadTs, folders, editors))
+			followUpFileIds = append(followUpFileIds, []string{change.FileId})
+		}
+		if len(fresh) != 0 {
+			freshMessages, freshFileIds := createFreshMessages(subscription, prototype, fresh, folders, editors)
+			messages = append(messages, freshMessages...)
+			fileIds = append(fileIds, freshFileIds...)
+		}
+		messages = append(messages, followUps...)
+		fileIds = append(fileIds, followUpFileIds...)
+	}
+	return messages, fileIds
 }
 
 func CreateSlackWelcomeMessage(channel string, redirectUri string, sUserInfo *slack.UserInfo, version string) *slack.Message {
@@ -88,12 +346,17 @@ func CreateSlackWelcomeMessage(channel string, redirectUri string, sUserInfo *sl
 }
 
 func CreateSlackUnknownChannelMessage(subscription *Subscription, redirectUri string, source *slack.Message) *slack.Message {
-	nonExistentChannel := source.Channel
-	return &slack.Message{
+	nonExistentChannel := subscription.ChannelName(source.Channel)
+	message := &slack.Message{
 		Channel:     "@" + subscription.SlackUserInfo.User,
 		Username:    "Google Drive",
 		Text:        fmt.Sprintf("Hey <@%s|%s>, something is wrong: we can't find the slack channel %s: you should either create or <%s|change it>. Here is what happened in the meantime:", subscription.SlackUserInfo.User, subscription.SlackUserInfo.UserId, nonExistentChannel, redirectUri),
 		IconUrl:     source.IconUrl,
 		Attachments: source.Attachments,
 	}
+	if len(source.Blocks) != 0 {
+		// with blocks the text is only shown in notifications
+		message.Blocks = append([]slack.Block{slack.NewSectionBlock(message.Text)}, source.Blocks...)
+	}
+	return message
 }
@@ -1,9 +1,178 @@
 package gdrive2slack
 
 import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/optionfactory/gdrive2slack/google/drive"
+	"github.com/optionfactory/gdrive2slack/slack"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
+var update = flag.Bool("update", false, "update golden files")
+
+func assertGolden(t *testing.T, name string, actual interface{}) {
+	serialized, err := json.MarshalIndent(actual, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden := filepath.Join("testdata", name+".golden.json")
+	if *update {
+		ioutil.WriteFile(golden, append(serialized, '\n'), 0644)
+	}
+	expected, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bytes.TrimSpace(expected), serialized) {
+		t.Errorf("%s does not match, got:\n%s", golden, serialized)
+	}
+}
+
+func renderedChanges() []drive.ChangeItem {
+	return []drive.ChangeItem{
+		{
+			LastAction: drive.Modified,
+			Type:       drive.FileItemType,
+			File: drive.ChangedFile{
+				Title:             "Roadmap",
+				MimeType:          "application/vnd.google-apps.document",
+				AlternateLink:     "https://docs.google.com/document/d/roadmap",
+				LastModifyingUser: drive.User{DisplayName: "Jane Doe", EmailAddress: "jane@example.com"},
+				Parents:           []drive.Parent{{Id: "mockups"}},
+			},
+		},
+		{
+			LastAction: drive.Created,
+			Type:       drive.FileItemType,
+			File: drive.ChangedFile{
+				Title:             "logo.png",
+				MimeType:          "image/png",
+				AlternateLink:     "https://drive.google.com/file/d/logo",
+				LastModifyingUser: drive.User{DisplayName: "John"},
+				Parents:           []drive.Parent{{Id: "unknown"}},
+			},
+		},
+	}
+}
+
+func TestMessagesRenderedAsAttachments(t *testing.T) {
+	state := &UserState{Gdrive: &drive.State{ChangeSet: renderedChanges()}}
+	messages, _ := CreateSlackMessages(aSubscription(), state, routingFolders, nil, "test", time.Now())
+	assertGolden(t, "attachments", messages)
+}
+
+func TestMessagesRenderedAsBlocks(t *testing.T) {
+	subscription := aSubscription()
+	subscription.Renderer = BlocksRenderer
+	state := &UserState{Gdrive: &drive.State{ChangeSet: renderedChanges(), Omitted: 2}}
+	messages, _ := CreateSlackMessages(subscription, state, routingFolders, nil, "test", time.Now())
+	assertGolden(t, "blocks", messages)
+}
+
+func TestDigestRenderedAsBlocks(t *testing.T) {
+	subscription := aSubscription()
+	subscription.Renderer = BlocksRenderer
+	subscription.Digest = &DigestConfiguration{Frequency: WeeklyDigest}
+	digest := NewDigestState(time.Now())
+	digest.Add(renderedChanges(), 0)
+	assertGolden(t, "digest-blocks", CreateSlackDigestMessages(subscription, digest, routingFolders, nil, "test"))
+}
+
+func syntheticChanges(count int) []drive.ChangeItem {
+	changes := make([]drive.ChangeItem, 0, count)
+	for i := 0; i != count; i++ {
+		changes = append(changes, drive.ChangeItem{
+			LastAction: drive.Action(i % 4),
+			FileId:     fmt.Sprintf("file-%d", i),
+			File: drive.ChangedFile{
+				Title:             fmt.Sprintf("a rather long document title number %d %s", i, strings.Repeat("x", i%200)),
+				MimeType:          "application/vnd.google-apps.document",
+				AlternateLink:     fmt.Sprintf("https://docs.google.com/document/d/%d", i),
+				LastModifyingUser: drive.User{DisplayName: "Jane Doe", EmailAddress: "jane@example.com"},
+				Parents:           []drive.Parent{{Id: "mockups"}},
+			},
+		})
+	}
+	return changes
+}
+
+func assertMessagesWithinSlackLimits(t *testing.T, messages []*slack.Message) {
+	for _, message := range messages {
+		if len(message.Attachments) > maxAttachmentsPerMessage || len(message.Blocks) > maxBlocksPerMessage || jsonSize(message) > maxMessageSize+1000 {
+			t.Errorf("message too big: %d attachments, %d blocks, %d bytes", len(message.Attachments), len(message.Blocks), jsonSize(message))
+		}
+	}
+}
+
+func TestHundredsOfChangesAreSplitInAttachmentMessages(t *testing.T) {
+	state := &UserState{Gdrive: &drive.State{ChangeSet: syntheticChanges(500)}}
+	messages, _ := CreateSlackMessages(aSubscription(), state, routingFolders, nil, "test", time.Now())
+	assertMessagesWithinSlackLimits(t, messages)
+	total := 0
+	for _, message := range messages {
+		total += len(message.Attachments)
+	}
+	if len(messages) != 25 || total != 500 || messages[0].Text != "Activity on gdrive (configured by @j\u200bane) (1/25)" {
+		t.Error(len(messages), total, messages[0].Text)
+	}
+}
+
+func TestHundredsOfChangesAreSplitInBlockMessages(t *testing.T) {
+	subscription := aSubscription()
+	subscription.Renderer = BlocksRenderer
+	state := &UserState{Gdrive: &drive.State{ChangeSet: syntheticChanges(300)}}
+	messages, _ := CreateSlackMessages(subscription, state, routingFolders, nil, "test", time.Now())
+	assertMessagesWithinSlackLimits(t, messages)
+	total := 0
+	for i, message := range messages {
+		total += len(message.Blocks)
+		if message.Blocks[0].Type == "context" || (i != 0 && message.Blocks[0].Text.Text == message.Text) {
+			t.Error("changes split across messages or header repeated")
+		}
+	}
+	if total != 1+300*3 {
+		t.Error(total)
+	}
+}
+
+func TestSplitMessagesOfAChannelAreConsecutive(t *testing.T) {
+	subscription := aSubscription()
+	subscription.Routes = folderRoutes()
+	changes := append(syntheticChanges(50), aChange("", "finance", drive.Modified))
+	messages, _ := CreateSlackMessages(subscription, &UserState{Gdrive: &drive.State{ChangeSet: changes}}, routingFolders, nil, "test", time.Now())
+	channels := make([]string, 0)
+	for _, message := range messages {
+		channels = append(channels, message.Channel)
+	}
+	if strings.Join(channels, ",") != "#design,#design,#design,#finance" {
+		t.Error(channels)
+	}
+}
+
+func TestDigestsOfManyChangesAreSplit(t *testing.T) {
+	subscription := aSubscription()
+	subscription.Digest = &DigestConfiguration{Frequency: DailyDigest}
+	digest := NewDigestState(time.Now())
+	digest.Add(syntheticChanges(400), 0)
+	messages := CreateSlackDigestMessages(subscription, digest, routingFolders, nil, "test")
+	assertMessagesWithinSlackLimits(t, messages)
+	if len(messages) != 2 || len(messages[0].Attachments)+len(messages[1].Attachments) != 3+maxDigestAttachments {
+		t.Error(len(messages))
+	}
+}
+
+func TestIconsAreChosenByMimeType(t *testing.T) {
+	if iconFor("application/pdf") != ":closed_book:" || iconFor("video/mp4") != ":movie_camera:" || iconFor("text/plain") != ":page_facing_up:" {
+		t.Fail()
+	}
+}
+
 func TestInfixWithEmptyStringYieldsEmptyString(t *testing.T) {
 	if "" != infixZeroWidthSpace("") {
 		t.Fail()
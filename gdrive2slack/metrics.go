@@ -0,0 +1,125 @@
+package gdrive2slack
+
+import (
+	"github.com/optionfactory/gdrive2slack/google"
+	"github.com/optionfactory/gdrive2slack/google/drive"
+	"github.com/optionfactory/gdrive2slack/slack"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Metrics are exposed to prometheus on /metrics
+type Metrics struct {
+	Registry      *prometheus.Registry
+	polls         *prometheus.CounterVec
+	driveRequests *prometheus.HistogramVec
+	slackPosts    *prometheus.CounterVec
+	changes       *prometheus.CounterVec
+	loopDuration  prometheus.Histogram
+	subscriptions *prometheus.GaugeVec
+}
+
+func NewMetrics(env *Environment) *Metrics {
+	self := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		polls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "gdrive2slack",
+			Name:      "polls_total",
+			Help:      "Subscriptions served, by result: success, failure or removal after failing for too long.",
+		}, []string{"result"}),
+		driveRequests: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "gdrive2slack",
+			Name:      "drive_request_duration_seconds",
+			Help:      "Latency of the Google Drive api, by operation and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"operation", "status"}),
+		slackPosts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "gdrive2slack",
+			Name:      "slack_posts_total",
+			Help:      "Messages posted or updated on Slack, by status.",
+		}, []string{"status"}),
+		changes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "gdrive2slack",
+			Name:      "changes_detected_total",
+			Help:      "Changes detected on Google Drive, by action.",
+		}, []string{"action"}),
+		loopDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "gdrive2slack",
+			Name:      "loop_duration_seconds",
+			Help:      "Time taken to serve all the subscriptions, to be compared with the poll interval.",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+		}),
+		subscriptions: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "gdrive2slack",
+			Name:      "subscriptions",
+			Help:      "Stored subscriptions, by state: active or paused.",
+		}, []string{"state"}),
+	}
+	self.Registry.MustRegister(
+		self.polls,
+		self.driveRequests,
+		self.slackPosts,
+		self.changes,
+		self.loopDuration,
+		self.subscriptions,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: "gdrive2slack",
+			Name:      "register_queue_depth",
+			Help:      "Subscriptions waiting for the event loop to be stored.",
+		}, func() float64 {
+			return float64(len(env.RegisterChannel))
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: "gdrive2slack",
+			Name:      "poll_interval_seconds",
+			Help:      "Configured interval between two polls.",
+		}, func() float64 {
+			return float64(env.Configuration.Interval)
+		}),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		prometheus.NewGoCollector(),
+	)
+	return self
+}
+
+func (self *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(self.Registry, promhttp.HandlerOpts{})
+}
+
+// ObserveDrive records a call to the drive api started at started, the
+// status label being the snake cased name of status
+func (self *Metrics) ObserveDrive(operation string, started time.Time, status google.StatusCode) {
+	label := strings.ToLower(strings.Replace(status.String(), " ", "_", -1))
+	self.driveRequests.WithLabelValues(operation, label).Observe(time.Since(started).Seconds())
+}
+
+func (self *Metrics) CountSlackPost(status slack.StatusCode) {
+	self.slackPosts.WithLabelValues(status.String()).Inc()
+}
+
+func (self *Metrics) CountChanges(changes []drive.ChangeItem) {
+	for i := range changes {
+		self.changes.WithLabelValues(changes[i].LastAction.String()).Inc()
+	}
+}
+
+// CountPolls records the outcome of serving subscriptions, removals being
+// counted among failures by serve
+func (self *Metrics) CountPolls(served int, failures int, removals int) {
+	self.polls.WithLabelValues("success").Add(float64(served - failures))
+	self.polls.WithLabelValues("failure").Add(float64(failures - removals))
+	self.polls.WithLabelValues("removal").Add(float64(removals))
+}
+
+func (self *Metrics) ObserveLoop(duration time.Duration) {
+	self.loopDuration.Observe(duration.Seconds())
+}
+
+func (self *Metrics) SetSubscriptions(subscriptions *Subscriptions) {
+	active := len(subscriptions.ActiveKeys())
+	self.subscriptions.WithLabelValues("active").Set(float64(active))
+	self.subscriptions.WithLabelValues("paused").Set(float64(len(subscriptions.Info) - active))
+}
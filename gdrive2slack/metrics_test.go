@@ -0,0 +1,45 @@
+package gdrive2slack
+
+import (
+	"github.com/optionfactory/gdrive2slack/google"
+	"github.com/optionfactory/gdrive2slack/google/drive"
+	"github.com/optionfactory/gdrive2slack/slack"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func scrape(metrics *Metrics) string {
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	metrics.Handler().ServeHTTP(recorder, req)
+	return recorder.Body.String()
+}
+
+func TestMetricsAreExposedInTheTextFormat(t *testing.T) {
+	env := &Environment{Configuration: &Configuration{Interval: 30}, RegisterChannel: make(chan *SubscriptionAndAccessToken, 50)}
+	metrics := NewMetrics(env)
+	env.RegisterChannel <- &SubscriptionAndAccessToken{}
+	metrics.CountPolls(10, 3, 1)
+	metrics.CountChanges([]drive.ChangeItem{aChange("", "design", drive.Modified), aChange("", "design", drive.Modified)})
+	metrics.CountSlackPost(slack.RateLimited)
+	metrics.ObserveDrive("changes", time.Now(), google.Ok)
+	exposed := scrape(metrics)
+	expected := []string{
+		`gdrive2slack_polls_total{result="success"} 7`,
+		`gdrive2slack_polls_total{result="failure"} 2`,
+		`gdrive2slack_polls_total{result="removal"} 1`,
+		`gdrive2slack_changes_detected_total{action="Modified"} 2`,
+		`gdrive2slack_slack_posts_total{status="rate_limited"} 1`,
+		`gdrive2slack_drive_request_duration_seconds_count{operation="changes",status="ok"} 1`,
+		`gdrive2slack_register_queue_depth 1`,
+		`gdrive2slack_poll_interval_seconds 30`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(exposed, line) {
+			t.Error("missing", line)
+		}
+	}
+}
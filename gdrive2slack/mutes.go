@@ -0,0 +1,174 @@
+package gdrive2slack
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/optionfactory/gdrive2slack/google/drive"
+	"github.com/optionfactory/gdrive2slack/slack"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	muteFileAction   = "mute_file"
+	muteFolderAction = "mute_folder"
+	fileMuteDuration = 24 * time.Hour
+)
+
+// Mute silences the changes to a file, or to the files in a folder and its
+// subfolders, until the given time or forever when Until is nil
+type Mute struct {
+	FileId   string     `json:"file_id,omitempty"`
+	FolderId string     `json:"folder_id,omitempty"`
+	Until    *time.Time `json:"until"`
+	MutedBy  string     `json:"muted_by"`
+}
+
+func (self *Mute) IsActive(now time.Time) bool {
+	return self.Until == nil || now.Before(*self.Until)
+}
+
+func (self *Mute) Matches(change *drive.ChangeItem, folders *drive.Folders) bool {
+	if self.FileId != "" {
+		return change.FileId == self.FileId
+	}
+	return folders.FolderIsOrIsContainedInAny(change.File.Parents, []string{self.FolderId})
+}
+
+// UnmutedChanges drops the changes silenced by an active mute
+func UnmutedChanges(mutes []*Mute, changes []drive.ChangeItem, folders *drive.Folders, now time.Time) []drive.ChangeItem {
+	if len(mutes) == 0 {
+		return changes
+	}
+	unmuted := make([]drive.ChangeItem, 0, len(changes))
+	for i := 0; i != len(changes); i++ {
+		muted := false
+		for _, mute := range mutes {
+			if mute.IsActive(now) && mute.Matches(&changes[i], folders) {
+				muted = true
+				break
+			}
+		}
+		if !muted {
+			unmuted = append(unmuted, changes[i])
+		}
+	}
+	return unmuted
+}
+
+// addMute replaces the mutes of the same file or folder, expired ones are dropped
+func addMute(mutes []*Mute, mute *Mute, now time.Time) []*Mute {
+	kept := make([]*Mute, 0, len(mutes)+1)
+	for _, m := range mutes {
+		if m.IsActive(now) && (m.FileId != mute.FileId || m.FolderId != mute.FolderId) {
+			kept = append(kept, m)
+		}
+	}
+	return append(kept, mute)
+}
+
+// muteActionsBlock offers to mute the file of the change or its folder, the
+// value of the buttons being the id of the subscription followed by the id
+// of the file or folder
+func muteActionsBlock(subscription *Subscription, change *drive.ChangeItem) (slack.Block, bool) {
+	buttons := make([]*slack.Button, 0, 2)
+	if change.FileId != "" {
+		buttons = append(buttons, slack.NewButton(muteFileAction, subscription.Id+" "+change.FileId, "Mute this file for 1 day"))
+	}
+	if len(change.File.Parents) != 0 {
+		buttons = append(buttons, slack.NewButton(muteFolderAction, subscription.Id+" "+change.File.Parents[0].Id, "Mute this folder"))
+	}
+	return slack.NewActionsBlock(buttons...), len(buttons) != 0
+}
+
+// withMuteActions appends the mute buttons to the blocks of a change
+func withMuteActions(subscription *Subscription, change *drive.ChangeItem, blocks []slack.Block) []slack.Block {
+	if actions, available := muteActionsBlock(subscription, change); available {
+		return append(blocks, actions)
+	}
+	return blocks
+}
+
+// RunMuteAction mutes the file or folder of a button clicked by a member of
+// the team of the subscription, it must be run by the event loop. The result
+// is the reply to the user.
+func RunMuteAction(env *Environment, subscriptions *Subscriptions, interaction *slack.Interaction, action *slack.Action, now time.Time) string {
+	parts := strings.SplitN(action.Value, " ", 2)
+	if len(parts) != 2 {
+		return "Unknown action."
+	}
+	subscription, found := subscriptions.Info[parts[0]]
+	if !found || subscription.SlackUserInfo.TeamId != interaction.Team.Id {
+		return "This subscription does not exist anymore."
+	}
+	mute := &Mute{MutedBy: interaction.User.Username}
+	var reply string
+	switch action.ActionId {
+	case muteFileAction:
+		until := now.Add(fileMuteDuration)
+		mute.FileId = parts[1]
+		mute.Until = &until
+		reply = fmt.Sprintf("Changes to this file will not be notified to %s for 1 day.", subscription.Channel)
+	case muteFolderAction:
+		mute.FolderId = parts[1]
+		reply = fmt.Sprintf("Changes to this folder will not be notified to %s anymore, `/gdrive unmute` to undo.", subscription.Channel)
+	default:
+		return "Unknown action."
+	}
+	subscription.Mutes = addMute(subscription.Mutes, mute, now)
+	env.Logger.Info("[%s/%s] *subscription %s: %s %s by %s", subscription.GoogleUserInfo.Email, subscription.SlackUserInfo.User, subscription.Id, action.ActionId, parts[1], interaction.User.Username)
+	if err := subscriptions.Save(subscription.Id); err != nil {
+		env.Logger.Warning("[%s/%s] cannot store subscription: %s", subscription.GoogleUserInfo.Email, subscription.SlackUserInfo.User, err)
+	}
+	return reply
+}
+
+// handleInteraction serves the buttons of the messages, it is disabled unless
+// the slack signing secret is configured
+func handleInteraction(env *Environment, req *http.Request) int {
+	signingSecret := env.Configuration.Slack.SigningSecret
+	if signingSecret == "" {
+		return 404
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, 1<<20))
+	if err != nil {
+		return 400
+	}
+	if !slack.VerifyRequest(signingSecret, req.Header, body, time.Now()) {
+		return 401
+	}
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return 400
+	}
+	var interaction slack.Interaction
+	if err := json.Unmarshal([]byte(values.Get("payload")), &interaction); err != nil {
+		return 400
+	}
+	// slack expects an answer within 3 seconds, the outcome is posted to the
+	// response url once the event loop has run the actions
+	accepted := env.Requests.Go(func() {
+		runInteraction(env, &interaction)
+	})
+	if !accepted {
+		return 503
+	}
+	return 200
+}
+
+func runInteraction(env *Environment, interaction *slack.Interaction) {
+	for i := range interaction.Actions {
+		action := &interaction.Actions[i]
+		result := make(chan string, 1)
+		env.CommandChannel <- func(subscriptions *Subscriptions) {
+			result <- RunMuteAction(env, subscriptions, interaction, action, time.Now())
+		}
+		if _, err := slack.Respond(env.HttpClient, interaction.ResponseUrl, <-result); err != nil {
+			env.Logger.Warning("cannot respond to slack interaction: %s", err)
+		}
+	}
+}
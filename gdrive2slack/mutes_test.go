@@ -0,0 +1,122 @@
+package gdrive2slack
+
+import (
+	"encoding/json"
+	"github.com/optionfactory/gdrive2slack/google/drive"
+	"github.com/optionfactory/gdrive2slack/slack"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMutedFilesAndFoldersAreNotNotified(t *testing.T) {
+	now := time.Now()
+	yesterday := now.Add(-time.Hour)
+	mutes := []*Mute{
+		{FileId: "noisy", Until: &now},
+		{FolderId: "design"},
+		{FileId: "expired", Until: &yesterday},
+	}
+	noisy := aChange("", "other", drive.Modified)
+	noisy.FileId = "noisy"
+	expired := aChange("", "other", drive.Modified)
+	expired.FileId = "expired"
+	changes := []drive.ChangeItem{
+		noisy,
+		expired,
+		aChange("", "mockups", drive.Modified),
+		aChange("", "finance", drive.Modified),
+	}
+	unmuted := UnmutedChanges(mutes, changes, routingFolders, now.Add(-time.Minute))
+	if len(unmuted) != 2 || unmuted[0].FileId != "expired" || unmuted[1].File.Parents[0].Id != "finance" {
+		t.Error(unmuted)
+	}
+}
+
+func TestMutingTheSameFileTwiceReplacesTheMute(t *testing.T) {
+	now := time.Now()
+	mutes := addMute(nil, &Mute{FileId: "a"}, now)
+	mutes = addMute(mutes, &Mute{FolderId: "a"}, now)
+	mutes = addMute(mutes, &Mute{FileId: "a", MutedBy: "john"}, now)
+	if len(mutes) != 2 || mutes[1].MutedBy != "john" {
+		t.Error(mutes)
+	}
+}
+
+func TestButtonsMuteFilesForADayAndFoldersForever(t *testing.T) {
+	defer cleanup(t, "/tmp", "temp-subs*")
+	subs := commandSubscriptions("#general")
+	id := subs.Keys()[0]
+	now := time.Now()
+	interaction := &slack.Interaction{Team: slack.InteractionTeam{Id: "T1"}, User: slack.InteractionUser{Username: "john"}}
+	RunMuteAction(anEnvironment(nil), subs, interaction, &slack.Action{ActionId: muteFileAction, Value: id + " noisy"}, now)
+	RunMuteAction(anEnvironment(nil), subs, interaction, &slack.Action{ActionId: muteFolderAction, Value: id + " design"}, now)
+	reloaded, _ := LoadSubscriptions(NewJsonStore("/tmp/temp-subs"))
+	mutes := reloaded.Info[id].Mutes
+	if len(mutes) != 2 || mutes[0].FileId != "noisy" || !mutes[0].Until.Equal(now.Add(fileMuteDuration)) || mutes[1].FolderId != "design" || mutes[1].Until != nil || mutes[1].MutedBy != "john" {
+		t.Error(mutes)
+	}
+	runCommand(subs, "general", "unmute")
+	if len(subs.Info[id].Mutes) != 0 {
+		t.Fail()
+	}
+}
+
+func TestButtonsOnlyMuteSubscriptionsOfTheSameTeam(t *testing.T) {
+	defer cleanup(t, "/tmp", "temp-subs*")
+	subs := commandSubscriptions("#general")
+	id := subs.Keys()[0]
+	interaction := &slack.Interaction{Team: slack.InteractionTeam{Id: "T2"}}
+	RunMuteAction(anEnvironment(nil), subs, interaction, &slack.Action{ActionId: muteFolderAction, Value: id + " design"}, time.Now())
+	if len(subs.Info[id].Mutes) != 0 {
+		t.Fail()
+	}
+}
+
+func TestBlocksOfferToMuteTheFileAndItsFolder(t *testing.T) {
+	subscription := aSubscription()
+	subscription.Renderer = BlocksRenderer
+	change := aChange("noisy", "mockups", drive.Modified)
+	state := &UserState{Gdrive: &drive.State{ChangeSet: []drive.ChangeItem{change}}}
+	messages, _ := CreateSlackMessages(subscription, state, routingFolders, nil, "test", time.Now())
+	actions := messages[0].Blocks[len(messages[0].Blocks)-1]
+	if actions.Type != "actions" || len(actions.Elements) != 2 {
+		t.Fatal(actions)
+	}
+	if button := actions.Elements[0].(*slack.Button); button.ActionId != muteFileAction || button.Value != "s1 noisy" {
+		t.Error(button)
+	}
+}
+
+func TestButtonClicksAreAcknowledgedBeforeMuting(t *testing.T) {
+	defer cleanup(t, "/tmp", "temp-subs*")
+	subs := commandSubscriptions("#general")
+	id := subs.Keys()[0]
+	responses := make(respondingSlack, 1)
+	env := anEnvironment(nil)
+	env.Configuration.Slack = &slack.OauthConfiguration{SigningSecret: "secret"}
+	env.HttpClient = &http.Client{Transport: responses}
+	env.CommandChannel = make(chan func(*Subscriptions))
+	payload, _ := json.Marshal(&slack.Interaction{
+		Team:        slack.InteractionTeam{Id: "T1"},
+		Actions:     []slack.Action{{ActionId: muteFolderAction, Value: id + " design"}},
+		ResponseUrl: "https://hooks.slack.com/actions/1",
+	})
+	body := url.Values{"payload": {string(payload)}}.Encode()
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, _ := http.NewRequest("POST", "/slack/interactions", strings.NewReader(body))
+	req.Header.Set("X-Slack-Request-Timestamp", timestamp)
+	req.Header.Set("X-Slack-Signature", slack.RequestSignature("secret", timestamp, []byte(body)))
+	if status := handleInteraction(env, req); status != 200 {
+		t.Fatal(status)
+	}
+	command := <-env.CommandChannel
+	command(subs)
+	<-responses
+	if len(subs.Info[id].Mutes) != 1 {
+		t.Error(subs.Info[id].Mutes)
+	}
+}
@@ -0,0 +1,102 @@
+package gdrive2slack
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/optionfactory/gdrive2slack/google"
+	"github.com/optionfactory/gdrive2slack/google/drive"
+	"net/http"
+	"time"
+)
+
+type DriveNotification struct {
+	ChannelId string
+	Token     string
+	State     string
+}
+
+func randomToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// handleDriveNotification never blocks: when the event loop is lagging behind
+// notifications are dropped, polling will catch up with the changes anyway.
+func handleDriveNotification(env *Environment, req *http.Request) int {
+	notification := &DriveNotification{
+		ChannelId: req.Header.Get("X-Goog-Channel-ID"),
+		Token:     req.Header.Get("X-Goog-Channel-Token"),
+		State:     req.Header.Get("X-Goog-Resource-State"),
+	}
+	if notification.ChannelId == "" {
+		return 400
+	}
+	if notification.State == "sync" {
+		return 200
+	}
+	select {
+	case env.NotificationChannel <- notification:
+	default:
+		env.Logger.Warning("dropping drive notification for channel %s", notification.ChannelId)
+	}
+	return 200
+}
+
+// notifiedSubscriptions coalesces every pending notification, ignoring the
+// ones for unknown or expired channels.
+func notifiedSubscriptions(env *Environment, subscriptions *Subscriptions, first *DriveNotification) []string {
+	found := make(map[string]bool)
+	for notification := first; notification != nil; {
+		if k, ok := subscriptions.FindByWatchChannel(notification.ChannelId, notification.Token); ok && !subscriptions.Info[k].Paused {
+			found[k] = true
+		}
+		select {
+		case notification = <-env.NotificationChannel:
+		default:
+			notification = nil
+		}
+	}
+	keys := make([]string, 0, len(found))
+	for k := range found {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// channels are renewed when they would expire before the next two polls
+func renewWatchChannel(env *Environment, subscription *Subscription, userState *UserState) {
+	email := subscription.GoogleUserInfo.Email
+	slackUser := subscription.SlackUserInfo.User
+	margin := time.Duration(2*env.Configuration.Interval)*time.Second + time.Minute
+	if userState.Watch != nil && userState.Watch.Expiration.After(time.Now().Add(margin)) {
+		return
+	}
+	previous := userState.Watch
+	ttl := time.Duration(env.Configuration.Push.Ttl) * time.Second
+	started := time.Now()
+	statusCode, err, channel := drive.Watch(env.HttpClient, userState.Gdrive, userState.GoogleAccessToken, env.Configuration.Push.Address, randomToken(), randomToken(), ttl)
+	env.Metrics.ObserveDrive("watch", started, statusCode)
+	if statusCode != google.Ok {
+		env.Logger.Warning("[%s/%s] cannot watch for changes: %s", email, slackUser, err)
+		return
+	}
+	userState.Watch = channel
+	if previous == nil {
+		return
+	}
+	if statusCode, err := drive.StopWatching(env.HttpClient, userState.GoogleAccessToken, previous); statusCode != google.Ok {
+		env.Logger.Warning("[%s/%s] cannot stop watch channel %s: %s", email, slackUser, previous.Id, err)
+	}
+}
+
+// stopWatchChannel stops the channel of a subscription going away or being
+// replaced. Best effort: an unknown channel is ignored anyway until it expires.
+func stopWatchChannel(env *Environment, accessToken string, channel *drive.WatchChannel) {
+	if channel == nil || accessToken == "" {
+		return
+	}
+	go drive.StopWatching(env.HttpClient, accessToken, channel)
+}
@@ -0,0 +1,71 @@
+package gdrive2slack
+
+import (
+	"github.com/optionfactory/gdrive2slack/google/drive"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func notificationsServer(env *Environment) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(handleDriveNotification(env, r))
+	}))
+}
+
+func notify(t *testing.T, server *httptest.Server, channelId string, token string, state string) int {
+	req, _ := http.NewRequest("POST", server.URL, nil)
+	req.Header.Set("X-Goog-Channel-ID", channelId)
+	req.Header.Set("X-Goog-Channel-Token", token)
+	req.Header.Set("X-Goog-Resource-State", state)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	return res.StatusCode
+}
+
+func TestNotificationsAreForwardedToTheEventLoop(t *testing.T) {
+	env := NewEnvironment("test", &Configuration{}, NewLogger(os.Stdout, "", 0))
+	server := notificationsServer(env)
+	defer server.Close()
+	if notify(t, server, "a-channel", "a-token", "change") != 200 {
+		t.Fail()
+	}
+	notification := <-env.NotificationChannel
+	if notification.ChannelId != "a-channel" || notification.Token != "a-token" {
+		t.Fail()
+	}
+}
+
+func TestSyncNotificationsAreIgnored(t *testing.T) {
+	env := NewEnvironment("test", &Configuration{}, NewLogger(os.Stdout, "", 0))
+	server := notificationsServer(env)
+	defer server.Close()
+	notify(t, server, "a-channel", "a-token", "sync")
+	if len(env.NotificationChannel) != 0 {
+		t.Fail()
+	}
+}
+
+func TestPendingNotificationsAreCoalesced(t *testing.T) {
+	env := NewEnvironment("test", &Configuration{}, NewLogger(os.Stdout, "", 0))
+	server := notificationsServer(env)
+	defer server.Close()
+	subs := &Subscriptions{
+		Info: map[string]*Subscription{"a": aSubscription(), "b": aSubscription()},
+		States: map[string]*UserState{
+			"a": {Gdrive: drive.NewState(), Watch: &drive.WatchChannel{Id: "a-channel", Token: "a-token"}},
+			"b": {Gdrive: drive.NewState(), Watch: &drive.WatchChannel{Id: "b-channel", Token: "b-token"}},
+		},
+	}
+	notify(t, server, "a-channel", "a-token", "change")
+	notify(t, server, "a-channel", "a-token", "change")
+	notify(t, server, "b-channel", "not-the-token", "change")
+	keys := notifiedSubscriptions(env, subs, <-env.NotificationChannel)
+	if len(keys) != 1 || keys[0] != "a" || len(env.NotificationChannel) != 0 {
+		t.Error(keys)
+	}
+}
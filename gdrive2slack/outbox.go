@@ -0,0 +1,167 @@
+package gdrive2slack
+
+import (
+	"github.com/optionfactory/gdrive2slack/slack"
+	"sync"
+	"time"
+)
+
+const (
+	// drive changes are left on drive while this many messages are undelivered
+	maxOutboxMessages   = 100
+	maxDeliveryAttempts = 10
+	minDeliveryBackoff  = 30 * time.Second
+	maxDeliveryBackoff  = time.Hour
+)
+
+// OutboxEntry is a message waiting to be delivered, persisted with the user
+// state so that it survives restarts.
+type OutboxEntry struct {
+	Message       *slack.Message `json:"message"`
+	Attempts      int            `json:"attempts"`
+	NextAttemptAt time.Time      `json:"next_attempt_at"`
+	// Threaded entries are posted in the thread of the closest preceding
+	// entry which is not threaded
+	Threaded bool `json:"threaded"`
+	// FileIds are the files notified by the message, used for file threads
+	// and file messages
+	FileIds []string `json:"file_ids,omitempty"`
+	// Update is the posted message to replace instead of posting a new one
+	Update *slack.PostedMessage `json:"update,omitempty"`
+}
+
+// SlackThrottle remembers until when each slack team asked us to stop
+// posting. It is shared by the workers.
+type SlackThrottle struct {
+	lock         sync.Mutex
+	blockedUntil map[string]time.Time
+}
+
+func NewSlackThrottle() *SlackThrottle {
+	return &SlackThrottle{
+		blockedUntil: make(map[string]time.Time),
+	}
+}
+
+func (self *SlackThrottle) BlockedUntil(teamId string) time.Time {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.blockedUntil[teamId]
+}
+
+func (self *SlackThrottle) Block(teamId string, until time.Time) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if until.After(self.blockedUntil[teamId]) {
+		self.blockedUntil[teamId] = until
+	}
+}
+
+func deliveryBackoff(attempts int) time.Duration {
+	backoff := minDeliveryBackoff
+	for i := 1; i < attempts && backoff < maxDeliveryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxDeliveryBackoff {
+		return maxDeliveryBackoff
+	}
+	return backoff
+}
+
+func isTransientFailure(status slack.StatusCode) bool {
+	return status == slack.CannotConnect || status == slack.CannotDeserialize || status == slack.UnknownError
+}
+
+// enqueueMessages appends the messages to the outbox. When the subscription
+// asks for it, the parts of a split message are threaded under the first one.
+// fileIds, when not nil, holds the files notified by each message.
+func enqueueMessages(subscription *Subscription, userState *UserState, messages []*slack.Message, fileIds [][]string) {
+	entries := make([]*OutboxEntry, 0, len(messages))
+	for i, message := range messages {
+		// replies to file threads are never parts of a split message
+		threaded := subscription.ThreadChunks && i != 0 && messages[i-1].Channel == message.Channel && message.ThreadTs == "" && messages[i-1].ThreadTs == ""
+		entry := &OutboxEntry{Message: message, Threaded: threaded}
+		if fileIds != nil {
+			entry.FileIds = fileIds[i]
+		}
+		entries = append(entries, entry)
+	}
+	enqueueEntries(userState, entries)
+}
+
+// enqueueEntries appends the entries to the outbox. Nothing is ever dropped
+// here: drive is not polled while the outbox is full.
+func enqueueEntries(userState *UserState, entries []*OutboxEntry) {
+	userState.Outbox = append(userState.Outbox, entries...)
+}
+
+// deliverOutbox posts the queued messages in order. Once a message has to
+// wait, because of slack rate limiting or of a transient failure, the
+// following ones wait as well. Returns true when the ids of channels known
+// by name only were learnt.
+func deliverOutbox(env *Environment, subscription *Subscription, userState *UserState) bool {
+	email := subscription.GoogleUserInfo.Email
+	slackUser := subscription.SlackUserInfo.User
+	teamId := subscription.SlackUserInfo.TeamId
+	now := time.Now()
+	kept := make([]*OutboxEntry, 0)
+	i := 0
+	defer func() {
+		// postMessage panics on authentication errors: keep what was not sent
+		userState.Outbox = append(kept, userState.Outbox[i:]...)
+	}()
+	waiting := false
+	learnt := false
+	for ; i != len(userState.Outbox); i++ {
+		entry := userState.Outbox[i]
+		if waiting || now.Before(env.SlackThrottle.BlockedUntil(teamId)) || now.Before(entry.NextAttemptAt) {
+			waiting = true
+			kept = append(kept, entry)
+			continue
+		}
+		posted, status, err := postMessage(env, subscription, entry.Message, entry.Update)
+		if status == slack.Ok {
+			userState.LastDeliveryAt = &now
+			if subscription.learnChannelId(entry.Message.Channel, posted.Channel) {
+				learnt = true
+			}
+			if subscription.FileThreads.IsFileThreadsConfigured() {
+				threadTs := entry.Message.ThreadTs
+				if threadTs == "" {
+					threadTs = posted.Ts
+				}
+				userState.rememberFileThreads(entry.Message.Channel, entry.FileIds, threadTs, now)
+			}
+			if subscription.UpdateInPlace && entry.Update == nil {
+				userState.rememberFileMessages(entry.Message.Channel, entry.FileIds, posted)
+			}
+			for j := i + 1; !entry.Threaded && j != len(userState.Outbox) && userState.Outbox[j].Threaded; j++ {
+				userState.Outbox[j].Message.ThreadTs = posted.Ts
+			}
+			continue
+		}
+		if rateLimit, ok := err.(*slack.RateLimitError); ok {
+			env.Logger.Info("[%s/%s] rate limited by slack for %v", email, slackUser, rateLimit.RetryAfter)
+			env.SlackThrottle.Block(teamId, now.Add(rateLimit.RetryAfter))
+			waiting = true
+			kept = append(kept, entry)
+			continue
+		}
+		if !isTransientFailure(status) {
+			if entry.Update != nil {
+				// most likely deleted from slack
+				userState.forgetFileMessages(entry.Message.Channel, entry.FileIds)
+			}
+			continue
+		}
+		entry.Attempts++
+		if entry.Attempts >= maxDeliveryAttempts {
+			env.Logger.Warning("[%s/%s] dropping message to %s after %d attempts", email, slackUser, subscription.ChannelName(entry.Message.Channel), entry.Attempts)
+			continue
+		}
+		entry.NextAttemptAt = now.Add(deliveryBackoff(entry.Attempts))
+		waiting = true
+		kept = append(kept, entry)
+	}
+	return learnt
+}
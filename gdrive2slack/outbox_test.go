@@ -0,0 +1,186 @@
+package gdrive2slack
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/optionfactory/gdrive2slack/google/drive"
+	"github.com/optionfactory/gdrive2slack/slack"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeSlackResponse struct {
+	statusCode int
+	retryAfter string
+	body       string
+}
+
+// fakeSlack answers chat.postMessage with the given responses, the last one
+// being repeated, and records the posted channels
+type fakeSlack struct {
+	responses []fakeSlackResponse
+	posted    []string
+	threads   []string
+}
+
+func (self *fakeSlack) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.ParseForm()
+	self.posted = append(self.posted, req.PostForm.Get("channel"))
+	self.threads = append(self.threads, req.PostForm.Get("thread_ts"))
+	r := self.responses[0]
+	if len(self.responses) > 1 {
+		self.responses = self.responses[1:]
+	}
+	response := &http.Response{
+		StatusCode: r.statusCode,
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(bytes.NewBufferString(r.body)),
+	}
+	if r.retryAfter != "" {
+		response.Header.Set("Retry-After", r.retryAfter)
+	}
+	return response, nil
+}
+
+var (
+	slackOk          = fakeSlackResponse{200, "", `{"ok":true,"channel":"C0123","ts":"1425.01"}`}
+	slackRateLimited = fakeSlackResponse{429, "30", `{"ok":false,"error":"ratelimited"}`}
+	slackDown        = fakeSlackResponse{503, "", `<html>down</html>`}
+	slackArchived    = fakeSlackResponse{200, "", `{"ok":false,"error":"is_archived"}`}
+)
+
+func queued(channels ...string) *UserState {
+	state := &UserState{}
+	for _, channel := range channels {
+		state.Outbox = append(state.Outbox, &OutboxEntry{Message: &slack.Message{Channel: channel}})
+	}
+	return state
+}
+
+func TestDeliveredMessagesLeaveTheOutbox(t *testing.T) {
+	fake := &fakeSlack{responses: []fakeSlackResponse{slackOk}}
+	state := queued("#a", "#b")
+	deliverOutbox(anEnvironment(fake), aSubscription(), state)
+	if len(state.Outbox) != 0 || len(fake.posted) != 2 {
+		t.Fail()
+	}
+}
+
+func TestRateLimitedMessagesWaitForRetryAfter(t *testing.T) {
+	fake := &fakeSlack{responses: []fakeSlackResponse{slackOk, slackRateLimited}}
+	env := anEnvironment(fake)
+	state := queued("#a", "#b", "#c")
+	deliverOutbox(env, aSubscription(), state)
+	if len(state.Outbox) != 2 || state.Outbox[0].Message.Channel != "#b" || len(fake.posted) != 2 {
+		t.Error(fake.posted)
+	}
+	blockedFor := env.SlackThrottle.BlockedUntil(aSubscription().SlackUserInfo.TeamId).Sub(time.Now())
+	if blockedFor < 29*time.Second || blockedFor > 30*time.Second {
+		t.Error(blockedFor)
+	}
+	deliverOutbox(env, aSubscription(), state)
+	if len(fake.posted) != 2 || len(state.Outbox) != 2 {
+		t.Error(fake.posted)
+	}
+}
+
+func TestTransientFailuresAreRetriedWithBackoff(t *testing.T) {
+	fake := &fakeSlack{responses: []fakeSlackResponse{slackDown}}
+	state := queued("#a", "#b")
+	deliverOutbox(anEnvironment(fake), aSubscription(), state)
+	if len(state.Outbox) != 2 || state.Outbox[0].Attempts != 1 || !state.Outbox[0].NextAttemptAt.After(time.Now()) || len(fake.posted) != 1 {
+		t.Fail()
+	}
+}
+
+func TestPermanentFailuresAreDropped(t *testing.T) {
+	fake := &fakeSlack{responses: []fakeSlackResponse{slackArchived, slackOk}}
+	state := queued("#archived", "#b")
+	deliverOutbox(anEnvironment(fake), aSubscription(), state)
+	if len(state.Outbox) != 0 || len(fake.posted) != 2 {
+		t.Fail()
+	}
+}
+
+func TestMessagesAreDroppedAfterTooManyAttempts(t *testing.T) {
+	fake := &fakeSlack{responses: []fakeSlackResponse{slackDown}}
+	state := queued("#a")
+	state.Outbox[0].Attempts = maxDeliveryAttempts - 1
+	deliverOutbox(anEnvironment(fake), aSubscription(), state)
+	if len(state.Outbox) != 0 {
+		t.Fail()
+	}
+}
+
+func TestBackoffDoublesUpToAnHour(t *testing.T) {
+	if deliveryBackoff(1) != 30*time.Second || deliveryBackoff(2) != time.Minute || deliveryBackoff(20) != time.Hour {
+		t.Fail()
+	}
+}
+
+func TestOutboxSurvivesSerialization(t *testing.T) {
+	state := queued("#a")
+	state.Outbox[0].Message.Blocks = []slack.Block{slack.NewSectionBlock("text")}
+	serialized, _ := json.Marshal(state)
+	var deserialized UserState
+	if err := json.Unmarshal(serialized, &deserialized); err != nil || deserialized.Outbox[0].Message.Blocks[0].Text.Text != "text" {
+		t.Error(string(serialized))
+	}
+}
+
+func TestSplitMessagesAreThreadedUnderTheFirstPartWhenAsked(t *testing.T) {
+	fake := &fakeSlack{responses: []fakeSlackResponse{slackOk}}
+	subscription := aSubscription()
+	subscription.ThreadChunks = true
+	state := &UserState{}
+	messages := []*slack.Message{{Channel: "#a"}, {Channel: "#a"}, {Channel: "#a"}, {Channel: "#b"}}
+	env := anEnvironment(fake)
+	enqueueMessages(subscription, state, messages, nil)
+	deliverOutbox(env, subscription, state)
+	if strings.Join(fake.threads, ",") != ",1425.01,1425.01," {
+		t.Error(fake.threads)
+	}
+}
+
+func TestSplitMessagesAreNotThreadedByDefault(t *testing.T) {
+	fake := &fakeSlack{responses: []fakeSlackResponse{slackOk}}
+	state := &UserState{}
+	env := anEnvironment(fake)
+	enqueueMessages(aSubscription(), state, []*slack.Message{{Channel: "#a"}, {Channel: "#a"}}, nil)
+	deliverOutbox(env, aSubscription(), state)
+	if strings.Join(fake.threads, ",") != "," {
+		t.Error(fake.threads)
+	}
+}
+
+func TestOutboxIsDeliveredWhenDriveFails(t *testing.T) {
+	fake := &fakeSlack{responses: []fakeSlackResponse{slackDown, slackOk}}
+	env := anEnvironment(fake)
+	env.Configuration = &Configuration{}
+	state := queued("#general")
+	state.Gdrive = drive.NewState()
+	serveUserTask(env, aSubscription(), state)
+	if len(state.Outbox) != 0 || state.Gdrive.PageToken != "" {
+		t.Error(fake.posted)
+	}
+}
+
+func TestDriveIsNotPolledWhileTheOutboxIsFull(t *testing.T) {
+	fake := &fakeSlack{responses: []fakeSlackResponse{slackOk}}
+	env := anEnvironment(fake)
+	env.Configuration = &Configuration{}
+	channels := make([]string, maxOutboxMessages)
+	for i := range channels {
+		channels[i] = "#general"
+	}
+	state := queued(channels...)
+	state.Gdrive = drive.NewState()
+	state.Gdrive.PageToken = "42"
+	serveUserTask(env, aSubscription(), state)
+	if len(fake.posted) != maxOutboxMessages || len(state.Outbox) != 0 || state.Gdrive.PageToken != "42" {
+		t.Error(len(fake.posted), len(state.Outbox))
+	}
+}
@@ -0,0 +1,105 @@
+package gdrive2slack
+
+import (
+	"errors"
+	"fmt"
+	"github.com/optionfactory/gdrive2slack/google/drive"
+)
+
+// Route sends changes to files contained in FolderIds (any file, when empty)
+// to Channel. Actions and MimeTypes, when given, further restrict the changes.
+// ChannelId, once resolved, is used in place of the name of the channel.
+type Route struct {
+	FolderIds []string `json:"folder_ids"`
+	Channel   string   `json:"channel"`
+	ChannelId string   `json:"channel_id"`
+	Actions   []string `json:"actions"`
+	MimeTypes []string `json:"mime_types"`
+}
+
+func containsString(haystack []string, needle string) bool {
+	for _, s := range haystack {
+		if s == needle {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *Route) Validate() error {
+	if self.Channel == "" {
+		return errors.New("Every route needs a slack channel")
+	}
+	for _, action := range self.Actions {
+		if !containsString(changeActions, action) {
+			return fmt.Errorf("invalid route action: '%s'", action)
+		}
+	}
+	return nil
+}
+
+func ValidateRoutes(routes []*Route) error {
+	for _, route := range routes {
+		if route == nil {
+			return errors.New("invalid empty route")
+		}
+		if err := route.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (self *Route) Matches(change *drive.ChangeItem, folders *drive.Folders) bool {
+	if len(self.FolderIds) != 0 && !folders.FolderIsOrIsContainedInAny(change.File.Parents, self.FolderIds) {
+		return false
+	}
+	if len(self.Actions) != 0 && !containsString(self.Actions, change.LastAction.String()) {
+		return false
+	}
+	if len(self.MimeTypes) != 0 && !containsString(self.MimeTypes, change.File.MimeType) {
+		return false
+	}
+	return true
+}
+
+// DefaultRoute is the subscription channel and folders, used for the changes
+// not matching any other route.
+func (self *Subscription) DefaultRoute() *Route {
+	return &Route{
+		FolderIds: self.GoogleInterestingFolderIds,
+		Channel:   self.Channel,
+		ChannelId: self.ChannelId,
+	}
+}
+
+// RouteChanges groups the changes by channel: a change is sent to every
+// matching route, but only once to each channel. Channels are yielded in the
+// order they are first needed, as ids when resolved.
+func RouteChanges(subscription *Subscription, changes []drive.ChangeItem, folders *drive.Folders) ([]string, map[string][]*drive.ChangeItem) {
+	channels := make([]string, 0)
+	routed := make(map[string][]*drive.ChangeItem)
+	add := func(channel string, change *drive.ChangeItem) {
+		if _, known := routed[channel]; !known {
+			channels = append(channels, channel)
+		}
+		routed[channel] = append(routed[channel], change)
+	}
+	defaultRoute := subscription.DefaultRoute()
+	for i := 0; i != len(changes); i++ {
+		change := &changes[i]
+		notified := make(map[string]bool)
+		for _, route := range subscription.Routes {
+			target := channelTarget(route.Channel, route.ChannelId)
+			if notified[target] || !route.Matches(change, folders) {
+				continue
+			}
+			notified[target] = true
+			add(target, change)
+		}
+		if len(notified) == 0 && defaultRoute.Matches(change, folders) {
+			add(channelTarget(defaultRoute.Channel, defaultRoute.ChannelId), change)
+		}
+	}
+	return channels, routed
+}
@@ -0,0 +1,88 @@
+package gdrive2slack
+
+import (
+	"github.com/optionfactory/gdrive2slack/google/drive"
+	"testing"
+)
+
+var routingFolders = drive.NewFolders(
+	&drive.Folder{Id: "design", Name: "design", ParentIds: []string{"root"}},
+	&drive.Folder{Id: "mockups", Name: "mockups", Path: "design", ParentIds: []string{"design"}},
+	&drive.Folder{Id: "finance", Name: "finance", ParentIds: []string{"root"}},
+	&drive.Folder{Id: "other", Name: "other", ParentIds: []string{"root"}},
+)
+
+// folderRoutes sends the changes in design and finance to their own channel, and
+// deletions in finance to #audit as well
+func folderRoutes() []*Route {
+	return []*Route{
+		{FolderIds: []string{"design"}, Channel: "#design"},
+		{FolderIds: []string{"finance"}, Channel: "#finance"},
+		{FolderIds: []string{"finance"}, Channel: "#audit", Actions: []string{"Deleted"}},
+	}
+}
+
+func TestChangesAreRoutedToTheChannelOfTheirFolder(t *testing.T) {
+	subscription := aSubscription()
+	subscription.Routes = folderRoutes()
+	changes := []drive.ChangeItem{aChange("", "mockups", drive.Modified), aChange("", "finance", drive.Modified)}
+	channels, routed := RouteChanges(subscription, changes, routingFolders)
+	if len(channels) != 2 || len(routed["#design"]) != 1 || len(routed["#finance"]) != 1 {
+		t.Error(channels)
+	}
+}
+
+func TestChangesMatchingManyRoutesFanOut(t *testing.T) {
+	subscription := aSubscription()
+	subscription.Routes = folderRoutes()
+	changes := []drive.ChangeItem{aChange("", "finance", drive.Deleted)}
+	channels, routed := RouteChanges(subscription, changes, routingFolders)
+	if len(channels) != 2 || len(routed["#finance"]) != 1 || len(routed["#audit"]) != 1 {
+		t.Error(channels)
+	}
+}
+
+func TestUnroutedChangesGoToTheSubscriptionChannel(t *testing.T) {
+	subscription := aSubscription()
+	subscription.Routes = folderRoutes()
+	changes := []drive.ChangeItem{aChange("", "other", drive.Modified)}
+	channels, routed := RouteChanges(subscription, changes, routingFolders)
+	if len(channels) != 1 || len(routed["#general"]) != 1 {
+		t.Error(channels)
+	}
+}
+
+func TestUnroutedChangesOutsideTheSubscriptionFoldersAreDropped(t *testing.T) {
+	s := aSubscription()
+	s.Routes = folderRoutes()
+	s.GoogleInterestingFolderIds = []string{"design"}
+	changes := []drive.ChangeItem{aChange("", "other", drive.Modified)}
+	if channels, _ := RouteChanges(s, changes, routingFolders); len(channels) != 0 {
+		t.Error(channels)
+	}
+}
+
+func TestAChannelIsNotifiedOncePerChange(t *testing.T) {
+	s := aSubscription()
+	s.Routes = append(folderRoutes(), &Route{FolderIds: []string{"mockups"}, Channel: "#design"})
+	changes := []drive.ChangeItem{aChange("", "mockups", drive.Modified)}
+	if _, routed := RouteChanges(s, changes, routingFolders); len(routed["#design"]) != 1 {
+		t.Fail()
+	}
+}
+
+func TestInvalidRoutesAreRejected(t *testing.T) {
+	invalid := [][]*Route{
+		{nil},
+		{{FolderIds: []string{"design"}}},
+		{{Channel: "#design", Actions: []string{"Renamed"}}},
+	}
+	for _, routes := range invalid {
+		if ValidateRoutes(routes) == nil {
+			t.Error("accepted", routes)
+		}
+	}
+	if err := ValidateRoutes([]*Route{{Channel: "#design", Actions: []string{"Deleted", "Shared"}}}); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,67 @@
+package gdrive2slack
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sessionCookieName = "gdrive2slack_session"
+	sessionTtl        = 12 * time.Hour
+)
+
+// A session proves the browser completed a google oauth flow for an email
+// address. The cookie value is "<base64 email>.<expiry>.<hex hmac>", signed
+// with the environment session key.
+func sessionSignature(key []byte, email string, expiry int64) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(email + "|" + strconv.FormatInt(expiry, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newSessionCookie(key []byte, email string, now time.Time) *http.Cookie {
+	expiry := now.Add(sessionTtl)
+	value := strings.Join([]string{
+		base64.RawURLEncoding.EncodeToString([]byte(email)),
+		strconv.FormatInt(expiry.Unix(), 10),
+		sessionSignature(key, email, expiry.Unix()),
+	}, ".")
+	return &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    value,
+		Path:     "/",
+		Expires:  expiry,
+		HttpOnly: true,
+		Secure:   true,
+	}
+}
+
+// sessionEmail returns the email address of a valid, unexpired session cookie
+func sessionEmail(key []byte, req *http.Request, now time.Time) (string, bool) {
+	cookie, err := req.Cookie(sessionCookieName)
+	if err != nil {
+		return "", false
+	}
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 3 {
+		return "", false
+	}
+	email, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", false
+	}
+	expiry, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || now.Unix() > expiry {
+		return "", false
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(sessionSignature(key, string(email), expiry))) {
+		return "", false
+	}
+	return string(email), true
+}
@@ -0,0 +1,37 @@
+package gdrive2slack
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func requestWithCookie(cookie *http.Cookie) *http.Request {
+	req, _ := http.NewRequest("GET", "/subscriptions", nil)
+	req.AddCookie(cookie)
+	return req
+}
+
+func TestSessionCookiesCarryTheEmail(t *testing.T) {
+	now := time.Now()
+	cookie := newSessionCookie([]byte("secret"), "user@example.com", now)
+	if email, ok := sessionEmail([]byte("secret"), requestWithCookie(cookie), now); !ok || email != "user@example.com" {
+		t.Fail()
+	}
+}
+
+func TestSessionCookiesSignedWithAnotherKeyAreRejected(t *testing.T) {
+	now := time.Now()
+	cookie := newSessionCookie([]byte("another secret"), "user@example.com", now)
+	if _, ok := sessionEmail([]byte("secret"), requestWithCookie(cookie), now); ok {
+		t.Fail()
+	}
+}
+
+func TestExpiredSessionCookiesAreRejected(t *testing.T) {
+	now := time.Now()
+	cookie := newSessionCookie([]byte("secret"), "user@example.com", now)
+	if _, ok := sessionEmail([]byte("secret"), requestWithCookie(cookie), now.Add(sessionTtl+time.Minute)); ok {
+		t.Fail()
+	}
+}
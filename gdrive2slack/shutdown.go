@@ -0,0 +1,147 @@
+package gdrive2slack
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// RequestGate lets requests in until it is closed, closing it waits for the
+// requests already in
+type RequestGate struct {
+	mutex    sync.Mutex
+	closed   bool
+	inFlight sync.WaitGroup
+	drained  chan struct{}
+}
+
+func NewRequestGate() *RequestGate {
+	return &RequestGate{drained: make(chan struct{})}
+}
+
+// Enter tells whether the request can be served, Leave must follow when it can
+func (self *RequestGate) Enter() bool {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.closed {
+		return false
+	}
+	self.inFlight.Add(1)
+	return true
+}
+
+func (self *RequestGate) Leave() {
+	self.inFlight.Done()
+}
+
+// Go runs task in the background as if it were a request, for shutdown to
+// wait for it. Returns false, without running task, once the gate is closed.
+func (self *RequestGate) Go(task func()) bool {
+	if !self.Enter() {
+		return false
+	}
+	go func() {
+		defer self.Leave()
+		task()
+	}()
+	return true
+}
+
+// Close turns new requests away and waits for the ones already in
+func (self *RequestGate) Close() {
+	self.mutex.Lock()
+	self.closed = true
+	self.mutex.Unlock()
+	self.inFlight.Wait()
+	close(self.drained)
+}
+
+// Drained is closed once Close has waited for the requests already in
+func (self *RequestGate) Drained() <-chan struct{} {
+	return self.drained
+}
+
+func (self *Environment) IsStopping() bool {
+	select {
+	case <-self.Stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+func (self *Environment) ShutdownTimeout() time.Duration {
+	return time.Duration(self.Configuration.ShutdownTimeout) * time.Second
+}
+
+// handleSignals starts the shutdown on the first signal, a second one exits
+// right away
+func handleSignals(env *Environment) {
+	s := <-env.SignalsChannel
+	env.Logger.Info("Stopping: got signal %v", s)
+	env.stop()
+	s = <-env.SignalsChannel
+	env.Logger.Warning("Exiting: got signal %v while stopping", s)
+	os.Exit(1)
+}
+
+// stop starts the shutdown: new requests are turned away from now on, the
+// event loop waits for the ones already in to drain
+func (self *Environment) stop() {
+	close(self.Stopping)
+	go self.Requests.Close()
+}
+
+// isProbe tells the requests still served while stopping
+func isProbe(req *http.Request) bool {
+	return req.URL.Path == "/healthz" || req.URL.Path == "/readyz" || req.URL.Path == "/metrics"
+}
+
+// shutdown lets the requests already accepted reach the event loop, then
+// stores the subscriptions and their states. Stopped is closed once done.
+func shutdown(env *Environment, store SubscriptionStore, subscriptions *Subscriptions) {
+	drained := env.Requests.Drained()
+	deadline := time.After(env.ShutdownTimeout())
+	for draining := true; draining; {
+		select {
+		case subscriptionAndAccessToken := <-env.RegisterChannel:
+			register(env, subscriptions, subscriptionAndAccessToken)
+		case command := <-env.CommandChannel:
+			command(subscriptions)
+		case <-drained:
+			draining = false
+		case <-deadline:
+			env.Logger.Warning("Stopping without waiting for the pending requests")
+			draining = false
+		}
+	}
+	for len(env.RegisterChannel) != 0 {
+		register(env, subscriptions, <-env.RegisterChannel)
+	}
+	if err := subscriptions.SaveStates(); err != nil {
+		env.Logger.Warning("cannot save subscription states: %s", err)
+	}
+	store.Close()
+	env.Logger.Info("Subscriptions stored")
+	close(env.Stopped)
+}
+
+// stopHttp closes the server once the event loop is stopped or the shutdown
+// timeout expires, whichever comes first, closing done when it is closed
+func stopHttp(env *Environment, server *http.Server, done chan<- struct{}) {
+	<-env.Stopping
+	deadline := time.Now().Add(env.ShutdownTimeout())
+	select {
+	case <-env.Stopped:
+	case <-time.After(deadline.Sub(time.Now())):
+		env.Logger.Warning("Event loop still busy after %v, exiting anyway", env.ShutdownTimeout())
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		env.Logger.Warning("Http server not closed cleanly: %s", err)
+	}
+	close(done)
+}
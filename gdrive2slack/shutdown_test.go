@@ -0,0 +1,101 @@
+package gdrive2slack
+
+import (
+	"github.com/optionfactory/gdrive2slack/google/userinfo"
+	"github.com/optionfactory/gdrive2slack/slack"
+	"os"
+	"testing"
+	"time"
+)
+
+func stoppingEnvironment() *Environment {
+	env := anEnvironment(nil)
+	env.Configuration.Workers = 2
+	env.Configuration.ShutdownTimeout = 5
+	env.RegisterChannel = make(chan *SubscriptionAndAccessToken, 50)
+	env.CommandChannel = make(chan func(*Subscriptions))
+	env.Stopping = make(chan struct{})
+	env.Stopped = make(chan struct{})
+	env.stop()
+	return env
+}
+
+func TestClosedGatesWaitForTheRequestsAlreadyIn(t *testing.T) {
+	gate := NewRequestGate()
+	if !gate.Enter() {
+		t.Fatal("gate closed")
+	}
+	closed := make(chan struct{})
+	go func() {
+		gate.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("closed with a request in")
+	case <-time.After(50 * time.Millisecond):
+	}
+	gate.Leave()
+	<-closed
+	if gate.Enter() {
+		t.Error("entered a closed gate")
+	}
+}
+
+func TestSubscriptionsAreNotServedWhileStopping(t *testing.T) {
+	defer cleanup(t, "/tmp", "temp-subs*")
+	subs := commandSubscriptions("#general", "#design")
+	served, failures, _ := serve(stoppingEnvironment(), subs, subs.Keys())
+	if served != 0 || failures != 0 {
+		t.Error(served, failures)
+	}
+	for _, state := range subs.States {
+		if state.FailingSince != nil {
+			t.Error("skipped subscriptions were handled")
+		}
+	}
+}
+
+func TestShutdownStoresThePendingRegistrations(t *testing.T) {
+	defer cleanup(t, "/tmp", "temp-subs*")
+	subs := commandSubscriptions("#general")
+	env := stoppingEnvironment()
+	env.RegisterChannel <- &SubscriptionAndAccessToken{
+		Subscription: &Subscription{
+			Channel:                    "#design",
+			GoogleUserInfo:             &userinfo.UserInfo{Email: "user@example.com"},
+			SlackUserInfo:              &slack.UserInfo{TeamId: "T1", UserId: "U1", User: "jane"},
+			GoogleInterestingFolderIds: []string{},
+		},
+		GoogleAccessToken: "a-fake-token",
+	}
+	shutdown(env, NewJsonStore("/tmp/temp-subs"), subs)
+	select {
+	case <-env.Stopped:
+	default:
+		t.Error("not stopped")
+	}
+	if !env.IsStopping() || env.Requests.Enter() {
+		t.Error("still accepting requests")
+	}
+	reloaded, _ := LoadSubscriptions(NewJsonStore("/tmp/temp-subs"))
+	if len(reloaded.Info) != 2 {
+		t.Error(reloaded.Info)
+	}
+}
+
+func TestSignalsTurnRequestsAwayRightAway(t *testing.T) {
+	env := anEnvironment(nil)
+	env.Stopping = make(chan struct{})
+	env.SignalsChannel = make(chan os.Signal, 1)
+	go handleSignals(env)
+	env.SignalsChannel <- os.Interrupt
+	select {
+	case <-env.Requests.Drained():
+	case <-time.After(time.Second):
+		t.Fatal("gate left open until the event loop stops")
+	}
+	if !env.IsStopping() || env.Requests.Enter() {
+		t.Error("still accepting requests")
+	}
+}
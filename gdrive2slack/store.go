@@ -0,0 +1,57 @@
+package gdrive2slack
+
+import (
+	"fmt"
+)
+
+// SubscriptionStore persists subscriptions and their user states.
+// It is only ever used from the event loop goroutine.
+type SubscriptionStore interface {
+	Load() (map[string]*Subscription, map[string]*UserState, error)
+	Upsert(key string, subscription *Subscription, state *UserState) error
+	// UpsertAll stores many subscriptions at once, states are only replaced when given
+	UpsertAll(info map[string]*Subscription, states map[string]*UserState) error
+	Delete(keys ...string) error
+	UpdateStates(states map[string]*UserState) error
+	Close() error
+}
+
+type StoreConfiguration struct {
+	Type       string                   `json:"type"`
+	Path       string                   `json:"path"`
+	Encryption *EncryptionConfiguration `json:"encryption"`
+}
+
+const (
+	JsonStoreType = "json"
+	BoltStoreType = "bolt"
+)
+
+func OpenStore(conf *StoreConfiguration) (SubscriptionStore, error) {
+	if conf == nil {
+		conf = &StoreConfiguration{}
+	}
+	cipher, err := NewTokenCipher(conf.Encryption)
+	if err != nil {
+		return nil, err
+	}
+	switch conf.Type {
+	case "", JsonStoreType:
+		path := conf.Path
+		if path == "" {
+			path = "subscriptions.json"
+		}
+		return NewEncryptingStore(NewJsonStore(path), cipher), nil
+	case BoltStoreType:
+		path := conf.Path
+		if path == "" {
+			path = "subscriptions.db"
+		}
+		store, err := OpenBoltStore(path)
+		if err != nil {
+			return nil, err
+		}
+		return NewEncryptingStore(store, cipher), nil
+	}
+	return nil, fmt.Errorf("unknown store type: '%s'", conf.Type)
+}
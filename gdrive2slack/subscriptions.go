@@ -1,121 +1,366 @@
 package gdrive2slack
 
 import (
-	"encoding/json"
 	"fmt"
 	"github.com/optionfactory/gdrive2slack/google/drive"
 	"github.com/optionfactory/gdrive2slack/google/userinfo"
 	"github.com/optionfactory/gdrive2slack/slack"
-	"os"
 	"time"
 )
 
 type Subscription struct {
-	Channel                    string             `json:"channel"`
-	SlackAccessToken           string             `json:"slack_access_token"`
-	GoogleRefreshToken         string             `json:"google_refresh_token"`
-	GoogleUserInfo             *userinfo.UserInfo `json:"guser"`
-	SlackUserInfo              *slack.UserInfo    `json:"suser"`
-	GoogleInterestingFolderIds []string           `json:"google_interesting_folder_ids"`
+	Id                         string                    `json:"id"`
+	Channel                    string                    `json:"channel"`
+	ChannelId                  string                    `json:"channel_id"`
+	SlackAccessToken           string                    `json:"slack_access_token"`
+	GoogleRefreshToken         string                    `json:"google_refresh_token"`
+	GoogleUserInfo             *userinfo.UserInfo        `json:"guser"`
+	SlackUserInfo              *slack.UserInfo           `json:"suser"`
+	GoogleInterestingFolderIds []string                  `json:"google_interesting_folder_ids"`
+	Routes                     []*Route                  `json:"routes"`
+	Rules                      []*Rule                   `json:"rules"`
+	Digest                     *DigestConfiguration      `json:"digest"`
+	Renderer                   string                    `json:"renderer"`
+	EditorMentions             string                    `json:"editor_mentions"`
+	ThreadChunks               bool                      `json:"thread_chunks"`
+	FileThreads                *FileThreadsConfiguration `json:"file_threads"`
+	UpdateInPlace              bool                      `json:"update_in_place"`
+	// Paused subscriptions are neither polled nor notified
+	Paused bool    `json:"paused"`
+	Mutes  []*Mute `json:"mutes"`
 }
 
 type UserState struct {
-	Gdrive            *drive.State
-	GoogleAccessToken string
-	FailingSince      *time.Time
+	Gdrive            *drive.State        `json:"gdrive"`
+	GoogleAccessToken string              `json:"-"`
+	FailingSince      *time.Time          `json:"failing_since"`
+	Watch             *drive.WatchChannel `json:"watch"`
+	Digest            *DigestState        `json:"digest"`
+	Outbox            []*OutboxEntry      `json:"outbox"`
+	// FileThreads are keyed by channel and file id
+	FileThreads map[string]*FileThread `json:"file_threads"`
+	// FileMessages are keyed by channel and file id
+	FileMessages map[string]*FileMessage `json:"file_messages"`
+	// ChannelsCheckedAt is when the channels of the subscription were last resolved
+	ChannelsCheckedAt *time.Time `json:"channels_checked_at"`
+	LastDeliveryAt    *time.Time `json:"last_delivery_at"`
 }
 
 type SubscriptionAndAccessToken struct {
 	Subscription      *Subscription
 	GoogleAccessToken string
+	// KeptSettings are taken from the subscription being replaced, see
+	// reconfigurableSettings
+	KeptSettings map[string]bool
 }
 
 type Subscriptions struct {
-	Source string
+	Store  SubscriptionStore
 	Info   map[string]*Subscription
 	States map[string]*UserState
+	// due are served by the event loop without waiting for the next poll
+	due map[string]bool
 }
 
-func LoadSubscriptions(filename string) (*Subscriptions, error) {
+func LoadSubscriptions(store SubscriptionStore) (*Subscriptions, error) {
+	info, states, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
 	var subscriptions = &Subscriptions{
-		Source: filename,
-		Info:   make(map[string]*Subscription),
+		Store:  store,
+		Info:   info,
 		States: make(map[string]*UserState),
 	}
-	file, err := os.Open(filename)
-	if err != nil {
-		return subscriptions, nil
-	}
-	defer file.Close()
-	err = json.NewDecoder(file).Decode(&subscriptions.Info)
-	if err != nil {
+	if err := migrateEmailKeys(store, info, states); err != nil {
 		return nil, err
 	}
 	for k, sub := range subscriptions.Info {
-		subscriptions.States[k] = &UserState{
-			Gdrive:            drive.NewState(),
-			GoogleAccessToken: "",
-			FailingSince:      nil,
+		state, found := states[k]
+		if !found || state == nil || state.Gdrive == nil {
+			state = &UserState{
+				Gdrive:            drive.NewState(),
+				GoogleAccessToken: "",
+				FailingSince:      nil,
+			}
 		}
+		subscriptions.States[k] = state
 		// handle migration from versions prior to folder filtering
 		if sub.GoogleInterestingFolderIds == nil {
 			sub.GoogleInterestingFolderIds = make([]string, 0)
 		}
+		// handle migration from versions prior to routing
+		if sub.Routes == nil {
+			sub.Routes = make([]*Route, 0)
+		}
+		// handle migration from versions prior to filtering rules
+		if sub.Rules == nil {
+			sub.Rules = make([]*Rule, 0)
+		}
 	}
 	return subscriptions, nil
 }
 
-func (subscriptions *Subscriptions) save() error {
-	s := func(filename string) error {
-		file, err := os.Create(filename)
-		if err != nil {
-			return err
+// migrateEmailKeys moves subscriptions stored by versions keyed by the google
+// email address to a freshly generated subscription id.
+func migrateEmailKeys(store SubscriptionStore, info map[string]*Subscription, states map[string]*UserState) error {
+	migratedInfo := make(map[string]*Subscription)
+	migratedStates := make(map[string]*UserState)
+	legacyKeys := make([]string, 0)
+	for k, sub := range info {
+		if sub.Id != "" {
+			continue
+		}
+		sub.Id = randomToken()
+		migratedInfo[sub.Id] = sub
+		if state, found := states[k]; found {
+			migratedStates[sub.Id] = state
 		}
-		defer file.Close()
-		return json.NewEncoder(file).Encode(subscriptions.Info)
+		legacyKeys = append(legacyKeys, k)
 	}
-	suffix := time.Now().Format("2006-01-02T15-04-05")
-	err1 := s(subscriptions.Source)
-	err2 := s(subscriptions.Source + "." + suffix)
-	if err1 != nil {
-		return err1
+	if len(legacyKeys) == 0 {
+		return nil
 	}
-	return err2
+	if err := store.UpsertAll(migratedInfo, migratedStates); err != nil {
+		return err
+	}
+	if err := store.Delete(legacyKeys...); err != nil {
+		return err
+	}
+	for _, k := range legacyKeys {
+		delete(info, k)
+		delete(states, k)
+	}
+	for k, sub := range migratedInfo {
+		info[k] = sub
+	}
+	for k, state := range migratedStates {
+		states[k] = state
+	}
+	return nil
 }
 
-func (subscriptions *Subscriptions) Add(subscription *Subscription, googleAccessToken string) {
-	subscriptions.Info[subscription.GoogleUserInfo.Email] = subscription
-	subscriptions.States[subscription.GoogleUserInfo.Email] = &UserState{
+func (subscriptions *Subscriptions) SaveStates() error {
+	return subscriptions.Store.UpdateStates(subscriptions.States)
+}
+
+// Replaced returns the subscription that subscription would replace: the one
+// with the same id, when it belongs to the same google account
+func (subscriptions *Subscriptions) Replaced(subscription *Subscription) (*Subscription, bool) {
+	existing, found := subscriptions.Info[subscription.Id]
+	if !found || existing.GoogleUserInfo.Email != subscription.GoogleUserInfo.Email {
+		return nil, false
+	}
+	return existing, true
+}
+
+// Add stores subscription under its id, replacing the existing subscription
+// with the same id only when it belongs to the same google account. Any other
+// subscription gets a new id. Returns true when a subscription was replaced:
+// where it was in the drive changes, what it had yet to deliver, its threads
+// and whether it was paused are kept, only the tokens and settings change.
+func (subscriptions *Subscriptions) Add(subscription *Subscription, googleAccessToken string) (bool, error) {
+	existing, replaced := subscriptions.Replaced(subscription)
+	state := &UserState{
 		Gdrive:            drive.NewState(),
 		GoogleAccessToken: googleAccessToken,
 	}
-	subscriptions.save()
+	if !replaced {
+		subscription.Id = randomToken()
+	} else {
+		// mutes are only managed from slack
+		subscription.Mutes = existing.Mutes
+		subscription.Paused = existing.Paused
+		previous := subscriptions.States[subscription.Id]
+		state.Gdrive = previous.Gdrive
+		state.Outbox = previous.Outbox
+		state.Digest = previous.Digest
+		state.FileThreads = previous.FileThreads
+		state.FileMessages = previous.FileMessages
+		state.LastDeliveryAt = previous.LastDeliveryAt
+	}
+	subscriptions.Info[subscription.Id] = subscription
+	subscriptions.States[subscription.Id] = state
+	return replaced, subscriptions.Store.Upsert(subscription.Id, subscription, state)
+}
+
+// keepSettings takes the given settings from previous, see
+// reconfigurableSettings
+func (self *Subscription) keepSettings(previous *Subscription, settings map[string]bool) {
+	if settings["c"] {
+		self.Channel = previous.Channel
+		self.ChannelId = previous.ChannelId
+	}
+	if settings["fids"] {
+		self.GoogleInterestingFolderIds = previous.GoogleInterestingFolderIds
+	}
+	if settings["routes"] {
+		self.Routes = previous.Routes
+	}
+	if settings["rules"] {
+		self.Rules = previous.Rules
+	}
+	if settings["digest"] {
+		self.Digest = previous.Digest
+	}
+	if settings["renderer"] {
+		self.Renderer = previous.Renderer
+	}
+	if settings["editors"] {
+		self.EditorMentions = previous.EditorMentions
+	}
+	if settings["thread"] {
+		self.ThreadChunks = previous.ThreadChunks
+	}
+	if settings["fileThreads"] {
+		self.FileThreads = previous.FileThreads
+	}
+	if settings["update"] {
+		self.UpdateInPlace = previous.UpdateInPlace
+	}
+}
+
+// Save stores the subscription after it has been changed in place
+func (subscriptions *Subscriptions) Save(id string) error {
+	return subscriptions.Store.Upsert(id, subscriptions.Info[id], subscriptions.States[id])
+}
+
+// SetPaused pauses or resumes the subscription with the given id, the changes
+// made while paused are not notified. The subscription is not saved.
+func (subscriptions *Subscriptions) SetPaused(id string, paused bool) {
+	subscription := subscriptions.Info[id]
+	if subscription.Paused && !paused {
+		subscriptions.States[id].Gdrive = drive.NewState()
+	}
+	subscription.Paused = paused
 }
 
-func (subscriptions *Subscriptions) HandleFailure(email string) (*Subscription, string, bool) {
-	s := subscriptions.Info[email]
-	state := subscriptions.States[email]
+// Remove deletes the subscription with the given id when it belongs to email
+func (subscriptions *Subscriptions) Remove(id string, email string) (*Subscription, *UserState, bool, error) {
+	s, found := subscriptions.Info[id]
+	if !found || s.GoogleUserInfo.Email != email {
+		return nil, nil, false, nil
+	}
+	state := subscriptions.States[id]
+	delete(subscriptions.States, id)
+	delete(subscriptions.Info, id)
+	return s, state, true, subscriptions.Store.Delete(id)
+}
+
+// HandleFailure records a failure to serve the subscription with the given
+// key, removing it once it has been failing for a day
+func (subscriptions *Subscriptions) HandleFailure(key string) (*Subscription, string, bool, error) {
+	s := subscriptions.Info[key]
+	state := subscriptions.States[key]
 	now := time.Now()
 	threshold := now.Add(-24 * time.Hour)
 	if state.FailingSince == nil {
 		state.FailingSince = &now
-		return s, "new_failure", false
+		return s, "new_failure", false, nil
 	}
 	if state.FailingSince.Before(threshold) {
-		delete(subscriptions.States, email)
-		delete(subscriptions.Info, email)
-		subscriptions.save()
-		return s, fmt.Sprintf("over_failure_threshold_since@%v", state.FailingSince.Unix()), true
+		delete(subscriptions.States, key)
+		delete(subscriptions.Info, key)
+		err := subscriptions.Store.Delete(key)
+		return s, fmt.Sprintf("over_failure_threshold_since@%v", state.FailingSince.Unix()), true, err
 	}
-	return s, fmt.Sprintf("still_failing_since@%v", state.FailingSince.Unix()), false
+	return s, fmt.Sprintf("still_failing_since@%v", state.FailingSince.Unix()), false, nil
+
+}
 
+func (subscriptions *Subscriptions) HandleSuccess(key string) {
+	subscriptions.States[key].FailingSince = nil
 }
 
-func (subscriptions *Subscriptions) HandleSuccess(email string) {
-	subscriptions.States[email].FailingSince = nil
+func (subscriptions *Subscriptions) Keys() []string {
+	keys := make([]string, 0, len(subscriptions.Info))
+	for k := range subscriptions.Info {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// ActiveKeys yields the subscriptions to serve, paused ones excluded
+func (subscriptions *Subscriptions) ActiveKeys() []string {
+	keys := make([]string, 0, len(subscriptions.Info))
+	for k, sub := range subscriptions.Info {
+		if !sub.Paused {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+func (subscriptions *Subscriptions) FindByWatchChannel(id string, token string) (string, bool) {
+	for k, state := range subscriptions.States {
+		if state.Watch != nil && state.Watch.Id == id && state.Watch.Token == token {
+			return k, true
+		}
+	}
+	return "", false
+}
+
+// MarkDue has the subscription with the given id served as soon as the event
+// loop is done with what it is doing
+func (subscriptions *Subscriptions) MarkDue(id string) {
+	if subscriptions.due == nil {
+		subscriptions.due = make(map[string]bool)
+	}
+	subscriptions.due[id] = true
 }
 
-func (subscriptions *Subscriptions) Contains(email string) bool {
-	_, ok := subscriptions.Info[email]
+// TakeDue returns the keys of the active subscriptions marked as due,
+// clearing the marks
+func (subscriptions *Subscriptions) TakeDue() []string {
+	keys := make([]string, 0, len(subscriptions.due))
+	for id := range subscriptions.due {
+		if subscriptions.IsActive(id) {
+			keys = append(keys, id)
+		}
+	}
+	subscriptions.due = nil
+	return keys
+}
+
+// IsActive tells whether the subscription with the given id exists and is not paused
+func (subscriptions *Subscriptions) IsActive(id string) bool {
+	sub, ok := subscriptions.Info[id]
+	return ok && !sub.Paused
+}
+
+func (subscriptions *Subscriptions) Contains(id string) bool {
+	_, ok := subscriptions.Info[id]
 	return ok
 }
+
+// ContainsEmail tells whether any subscription belongs to the google account
+func (subscriptions *Subscriptions) ContainsEmail(email string) bool {
+	for _, sub := range subscriptions.Info {
+		if sub.GoogleUserInfo.Email == email {
+			return true
+		}
+	}
+	return false
+}
+
+// FindByEmail returns the subscriptions of a google account in no particular order
+func (subscriptions *Subscriptions) FindByEmail(email string) []*Subscription {
+	found := make([]*Subscription, 0)
+	for _, sub := range subscriptions.Info {
+		if sub.GoogleUserInfo.Email == email {
+			found = append(found, sub)
+		}
+	}
+	return found
+}
+
+// FindBySlackUser returns the subscriptions configured by a slack user in no particular order
+func (subscriptions *Subscriptions) FindBySlackUser(teamId string, userId string) []*Subscription {
+	found := make([]*Subscription, 0)
+	for _, sub := range subscriptions.Info {
+		if sub.SlackUserInfo.TeamId == teamId && sub.SlackUserInfo.UserId == userId {
+			found = append(found, sub)
+		}
+	}
+	return found
+}
@@ -1,12 +1,16 @@
 package gdrive2slack
 
 import (
+	"encoding/json"
+	"github.com/optionfactory/gdrive2slack/google/drive"
 	"github.com/optionfactory/gdrive2slack/google/userinfo"
 	"github.com/optionfactory/gdrive2slack/slack"
+	"io/ioutil"
 	_ "log"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func cleanup(t *testing.T, root string, pattern string) {
@@ -20,16 +24,42 @@ func cleanup(t *testing.T, root string, pattern string) {
 }
 
 func TestCanDeserializeANonexistentFile(t *testing.T) {
-	subs, err := LoadSubscriptions("/tmp/not-a-real-file")
+	subs, err := LoadSubscriptions(NewJsonStore("/tmp/not-a-real-file"))
 	if err != nil || len(subs.Info) != 0 {
 		t.Fail()
 	}
 	_ = subs
 }
 
+func TestLoadingAnUnreadableFileFails(t *testing.T) {
+	ioutil.WriteFile("/tmp/temp-subs", []byte("{}"), 0600)
+	defer cleanup(t, "/tmp", "temp-subs*")
+	_, err := LoadSubscriptions(NewJsonStore("/tmp/temp-subs/subscriptions"))
+	if err == nil {
+		t.Error("expected an error loading from under a regular file")
+	}
+}
+
+func TestJsonStoreDoesNotShareSubscriptions(t *testing.T) {
+	store := NewJsonStore("/tmp/temp-subs")
+	defer cleanup(t, "/tmp", "temp-subs*")
+	subscription := &Subscription{Channel: "channel"}
+	store.Upsert("a", subscription, &UserState{})
+	subscription.Channel = "changed after upsert"
+	info, _, err := store.Load()
+	if err != nil || info["a"].Channel != "channel" {
+		t.Error("expected the upserted subscription", err, info["a"])
+	}
+	info["a"].Channel = "changed after load"
+	store.Upsert("b", &Subscription{Channel: "other"}, &UserState{})
+	reloaded, _, _ := NewJsonStore("/tmp/temp-subs").Load()
+	if reloaded["a"].Channel != "channel" {
+		t.Error("expected the loaded subscription to be a copy", reloaded["a"])
+	}
+}
+
 func TestAddingToSubscriptionsUpdatesTheFile(t *testing.T) {
-	subs, err := LoadSubscriptions("/tmp/not-a-real-file")
-	subs.Source = "/tmp/temp-subs"
+	subs, err := LoadSubscriptions(NewJsonStore("/tmp/temp-subs"))
 	subscription := &Subscription{
 		Channel:            "channel",
 		SlackAccessToken:   "slack-token",
@@ -39,15 +69,14 @@ func TestAddingToSubscriptionsUpdatesTheFile(t *testing.T) {
 	}
 	subs.Add(subscription, "a-fake-token")
 	defer cleanup(t, "/tmp/", "temp-subs*")
-	fi, err := os.Lstat(subs.Source)
+	fi, err := os.Lstat("/tmp/temp-subs")
 	if err != nil || fi.Size() == 0 {
 		t.Fail()
 	}
 }
 
-func TestAddingToSubscriptionsCreatesATimestampedFile(t *testing.T) {
-	subs, _ := LoadSubscriptions("/tmp/not-a-real-file")
-	subs.Source = "/tmp/temp-subs"
+func TestAddingToSubscriptionsDoesNotCreateBackups(t *testing.T) {
+	subs, _ := LoadSubscriptions(NewJsonStore("/tmp/temp-subs"))
 	subscription := &Subscription{
 		Channel:            "channel",
 		SlackAccessToken:   "slack-token",
@@ -57,15 +86,14 @@ func TestAddingToSubscriptionsCreatesATimestampedFile(t *testing.T) {
 	}
 	subs.Add(subscription, "a-fake-token")
 	defer cleanup(t, "/tmp", "temp-subs*")
-	files, _ := filepath.Glob("/tmp/temp-subs.*")
-	if len(files) != 1 {
-		t.Fail()
+	files, _ := filepath.Glob("/tmp/temp-subs*")
+	if len(files) != 2 {
+		t.Error("expected the subscriptions and their states only", files)
 	}
 }
 
 func TestCorrectlyDeserializeSerializedSubscriptions(t *testing.T) {
-	subs, _ := LoadSubscriptions("/tmp/not-a-real-file")
-	subs.Source = "/tmp/temp-subs"
+	subs, _ := LoadSubscriptions(NewJsonStore("/tmp/temp-subs"))
 	subscription := &Subscription{
 		Channel:            "channel",
 		SlackAccessToken:   "slack-token",
@@ -75,8 +103,157 @@ func TestCorrectlyDeserializeSerializedSubscriptions(t *testing.T) {
 	}
 	subs.Add(subscription, "a-fake-token")
 	defer cleanup(t, "/tmp", "temp-subs*")
-	deserialized, _ := LoadSubscriptions(subs.Source)
+	deserialized, _ := LoadSubscriptions(NewJsonStore("/tmp/temp-subs"))
 	if len(subs.Info) != len(deserialized.Info) {
 		t.Fail()
 	}
 }
+
+func TestSavedStatesSurviveAReload(t *testing.T) {
+	subs, _ := LoadSubscriptions(NewJsonStore("/tmp/temp-subs"))
+	subscription := &Subscription{
+		Channel:            "channel",
+		SlackAccessToken:   "slack-token",
+		GoogleRefreshToken: "g-refresh-token",
+		GoogleUserInfo:     &userinfo.UserInfo{Email: "user@example.com"},
+		SlackUserInfo:      &slack.UserInfo{},
+	}
+	subs.Add(subscription, "a-fake-token")
+	defer cleanup(t, "/tmp", "temp-subs*")
+	failingSince := time.Now().Add(-time.Hour).Round(time.Second)
+	state := subs.States[subscription.Id]
+	state.Gdrive.PageToken = "42"
+	state.Gdrive.InGracePeriod[drive.GracePeriodKey{FileTitle: "a title", LastModifyingUserEmail: "editor@example.com"}] = failingSince
+	state.FailingSince = &failingSince
+	if err := subs.SaveStates(); err != nil {
+		t.Fatal(err)
+	}
+	deserialized, _ := LoadSubscriptions(NewJsonStore("/tmp/temp-subs"))
+	got := deserialized.States[subscription.Id]
+	if got.Gdrive.PageToken != "42" || got.FailingSince == nil || !got.FailingSince.Equal(failingSince) {
+		t.Fail()
+	}
+	if at, ok := got.Gdrive.InGracePeriod[drive.GracePeriodKey{FileTitle: "a title", LastModifyingUserEmail: "editor@example.com"}]; !ok || !at.Equal(failingSince) {
+		t.Fail()
+	}
+}
+
+func TestOneGoogleAccountCanHaveManySubscriptions(t *testing.T) {
+	defer cleanup(t, "/tmp", "temp-subs*")
+	subs, _ := LoadSubscriptions(NewJsonStore("/tmp/temp-subs"))
+	first := &Subscription{Channel: "#first", GoogleUserInfo: &userinfo.UserInfo{Email: "user@example.com"}, SlackUserInfo: &slack.UserInfo{}}
+	second := &Subscription{Channel: "#second", GoogleUserInfo: &userinfo.UserInfo{Email: "user@example.com"}, SlackUserInfo: &slack.UserInfo{}}
+	subs.Add(first, "a-fake-token")
+	subs.Add(second, "a-fake-token")
+	if first.Id == "" || first.Id == second.Id || len(subs.FindByEmail("user@example.com")) != 2 {
+		t.Fail()
+	}
+}
+
+func TestAddingWithAnExistingIdReplacesOnlySubscriptionsOfTheSameAccount(t *testing.T) {
+	defer cleanup(t, "/tmp", "temp-subs*")
+	subs, _ := LoadSubscriptions(NewJsonStore("/tmp/temp-subs"))
+	original := &Subscription{Channel: "#before", GoogleUserInfo: &userinfo.UserInfo{Email: "user@example.com"}, SlackUserInfo: &slack.UserInfo{}}
+	subs.Add(original, "a-fake-token")
+
+	updated := &Subscription{Id: original.Id, Channel: "#after", GoogleUserInfo: &userinfo.UserInfo{Email: "user@example.com"}, SlackUserInfo: &slack.UserInfo{}}
+	if replaced, _ := subs.Add(updated, "a-fake-token"); !replaced || updated.Id != original.Id || subs.Info[original.Id].Channel != "#after" {
+		t.Fail()
+	}
+	hijack := &Subscription{Id: original.Id, Channel: "#evil", GoogleUserInfo: &userinfo.UserInfo{Email: "other@example.com"}, SlackUserInfo: &slack.UserInfo{}}
+	if replaced, _ := subs.Add(hijack, "a-fake-token"); replaced || hijack.Id == original.Id || subs.Info[original.Id].Channel != "#after" {
+		t.Fail()
+	}
+}
+
+func TestReplacingKeepsTheUndeliveredMessages(t *testing.T) {
+	defer cleanup(t, "/tmp", "temp-subs*")
+	subs, _ := LoadSubscriptions(NewJsonStore("/tmp/temp-subs"))
+	original := &Subscription{Channel: "#before", GoogleUserInfo: &userinfo.UserInfo{Email: "user@example.com"}, SlackUserInfo: &slack.UserInfo{}}
+	subs.Add(original, "a-fake-token")
+	subs.States[original.Id].Outbox = []*OutboxEntry{{Message: &slack.Message{Channel: "#before"}}}
+	subs.States[original.Id].Digest = NewDigestState(time.Now())
+	subs.States[original.Id].Digest.Add([]drive.ChangeItem{aChange("", "design", drive.Modified)}, 0)
+
+	updated := &Subscription{Id: original.Id, Channel: "#after", GoogleUserInfo: &userinfo.UserInfo{Email: "user@example.com"}, SlackUserInfo: &slack.UserInfo{}}
+	subs.Add(updated, "a-fake-token")
+	if state := subs.States[original.Id]; len(state.Outbox) != 1 || len(state.Digest.Pending) != 1 {
+		t.Error(state)
+	}
+}
+
+func TestRemovingChecksTheOwner(t *testing.T) {
+	defer cleanup(t, "/tmp", "temp-subs*")
+	subs, _ := LoadSubscriptions(NewJsonStore("/tmp/temp-subs"))
+	subscription := &Subscription{GoogleUserInfo: &userinfo.UserInfo{Email: "user@example.com"}, SlackUserInfo: &slack.UserInfo{}}
+	subs.Add(subscription, "a-fake-token")
+	if _, _, found, _ := subs.Remove(subscription.Id, "other@example.com"); found || !subs.Contains(subscription.Id) {
+		t.Fail()
+	}
+	if _, _, found, err := subs.Remove(subscription.Id, "user@example.com"); !found || err != nil || subs.Contains(subscription.Id) {
+		t.Fail()
+	}
+}
+
+func TestEmailKeyedSubscriptionsAreMigratedToIds(t *testing.T) {
+	defer cleanup(t, "/tmp", "temp-subs*")
+	legacy := []byte(`{"user@example.com":{"channel":"#general","guser":{"email":"user@example.com"},"suser":{}}}`)
+	legacyStates := []byte(`{"user@example.com":{"gdrive":{"page_token":"42"}}}`)
+	ioutil.WriteFile("/tmp/temp-subs", legacy, 0600)
+	ioutil.WriteFile("/tmp/temp-subs-states.json", legacyStates, 0600)
+	subs, err := LoadSubscriptions(NewJsonStore("/tmp/temp-subs"))
+	if err != nil || len(subs.Info) != 1 || subs.Contains("user@example.com") {
+		t.Fatal(err)
+	}
+	id := subs.Keys()[0]
+	if subs.Info[id].Id != id || subs.States[id].Gdrive.PageToken != "42" {
+		t.Fail()
+	}
+	reloaded, _ := LoadSubscriptions(NewJsonStore("/tmp/temp-subs"))
+	if !reloaded.Contains(id) || len(reloaded.Info) != 1 || reloaded.States[id].Gdrive.PageToken != "42" {
+		t.Fail()
+	}
+}
+
+func TestReconfiguringKeepsWhatTheRequestLeavesOut(t *testing.T) {
+	defer cleanup(t, "/tmp", "temp-subs*")
+	subs, _ := LoadSubscriptions(NewJsonStore("/tmp/temp-subs"))
+	original := &Subscription{
+		Channel:        "#general",
+		GoogleUserInfo: &userinfo.UserInfo{Email: "user@example.com"},
+		SlackUserInfo:  &slack.UserInfo{},
+		Routes:         []*Route{{FolderIds: []string{"design"}, Channel: "#design"}},
+		Digest:         &DigestConfiguration{Frequency: "daily", Hour: 9},
+		Renderer:       BlocksRenderer,
+	}
+	subs.Add(original, "a-fake-token")
+	state := subs.States[original.Id]
+	state.Gdrive.PageToken = "42"
+	state.FileThreads = map[string]*FileThread{fileThreadKey("#general", "f1"): {Ts: "1.5"}}
+
+	var r Request
+	var sent map[string]json.RawMessage
+	body := []byte(`{"id":"` + original.Id + `","g":"code","s":"code","c":"#random","fids":[]}`)
+	json.Unmarshal(body, &r)
+	json.Unmarshal(body, &sent)
+	register(anEnvironment(nil), subs, &SubscriptionAndAccessToken{
+		Subscription: &Subscription{
+			Id:                         original.Id,
+			Channel:                    r.Channel,
+			GoogleRefreshToken:         "a-new-refresh-token",
+			GoogleUserInfo:             &userinfo.UserInfo{Email: "user@example.com"},
+			SlackUserInfo:              &slack.UserInfo{},
+			GoogleInterestingFolderIds: r.FolderIds,
+			Renderer:                   AttachmentsRenderer,
+		},
+		GoogleAccessToken: "a-new-token",
+		KeptSettings:      r.keptSettings(sent),
+	})
+	sub := subs.Info[original.Id]
+	if sub.Channel != "#random" || sub.GoogleRefreshToken != "a-new-refresh-token" || len(sub.Routes) != 1 || sub.Digest == nil || sub.Renderer != BlocksRenderer {
+		t.Error(sub)
+	}
+	if state := subs.States[original.Id]; state.Gdrive.PageToken != "42" || len(state.FileThreads) != 1 || state.GoogleAccessToken != "a-new-token" {
+		t.Error(state)
+	}
+}
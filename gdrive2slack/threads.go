@@ -0,0 +1,85 @@
+package gdrive2slack
+
+import (
+	"fmt"
+	"time"
+)
+
+// bounds the window to a week
+const maxFileThreadsWindow = 7 * 24 * 60
+
+// FileThreadsConfiguration posts the changes to a file notified in a channel
+// less than Window minutes earlier as replies to that notification. Replies
+// about deleted files are shown in the channel as well when BroadcastDeletes
+// is set.
+type FileThreadsConfiguration struct {
+	Window           int  `json:"window"`
+	BroadcastDeletes bool `json:"broadcast_deletes"`
+}
+
+func (self *FileThreadsConfiguration) IsFileThreadsConfigured() bool {
+	return self != nil && self.Window > 0
+}
+
+func (self *FileThreadsConfiguration) Validate() error {
+	if self.Window < 0 || self.Window > maxFileThreadsWindow {
+		return fmt.Errorf("invalid file threads window: %d minutes", self.Window)
+	}
+	return nil
+}
+
+func (self *FileThreadsConfiguration) window() time.Duration {
+	return time.Duration(self.Window) * time.Minute
+}
+
+// FileThread is the message starting the thread about a file in a channel,
+// PostedAt is refreshed by every reply.
+type FileThread struct {
+	Ts       string    `json:"ts"`
+	PostedAt time.Time `json:"posted_at"`
+}
+
+func fileThreadKey(channel string, fileId string) string {
+	return channel + "|" + fileId
+}
+
+// fileThreadFor yields the ts of the message to reply to with a change to the file
+func (self *UserState) fileThreadFor(conf *FileThreadsConfiguration, channel string, fileId string, now time.Time) (string, bool) {
+	if !conf.IsFileThreadsConfigured() || fileId == "" {
+		return "", false
+	}
+	thread, found := self.FileThreads[fileThreadKey(channel, fileId)]
+	if !found || !now.Before(thread.PostedAt.Add(conf.window())) {
+		return "", false
+	}
+	return thread.Ts, true
+}
+
+// rememberFileThreads records the thread of a posted message for each of the
+// files it notified: the message itself or the one it replied to.
+func (self *UserState) rememberFileThreads(channel string, fileIds []string, threadTs string, now time.Time) {
+	if len(fileIds) == 0 || threadTs == "" {
+		return
+	}
+	if self.FileThreads == nil {
+		self.FileThreads = make(map[string]*FileThread)
+	}
+	for _, fileId := range fileIds {
+		if fileId != "" {
+			self.FileThreads[fileThreadKey(channel, fileId)] = &FileThread{threadTs, now}
+		}
+	}
+}
+
+// expireFileThreads forgets the threads which cannot be replied to anymore
+func (self *UserState) expireFileThreads(conf *FileThreadsConfiguration, now time.Time) {
+	if !conf.IsFileThreadsConfigured() {
+		self.FileThreads = nil
+		return
+	}
+	for key, thread := range self.FileThreads {
+		if !now.Before(thread.PostedAt.Add(conf.window())) {
+			delete(self.FileThreads, key)
+		}
+	}
+}
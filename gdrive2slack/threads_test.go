@@ -0,0 +1,111 @@
+package gdrive2slack
+
+import (
+	"github.com/optionfactory/gdrive2slack/google/drive"
+	"github.com/optionfactory/gdrive2slack/slack"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestChangesToRecentlyNotifiedFilesAreThreadReplies(t *testing.T) {
+	now := time.Now()
+	subscription := aSubscription()
+	subscription.FileThreads = &FileThreadsConfiguration{Window: 60, BroadcastDeletes: true}
+	state := &UserState{Gdrive: &drive.State{ChangeSet: []drive.ChangeItem{
+		aChange("known", "mockups", drive.Modified),
+		aChange("new", "mockups", drive.Modified),
+		aChange("deleted", "mockups", drive.Deleted),
+	}}}
+	state.rememberFileThreads("#general", []string{"known", "deleted"}, "1425.01", now.Add(-time.Minute))
+	messages, fileIds := CreateSlackMessages(subscription, state, routingFolders, nil, "test", now)
+	if len(messages) != 3 || len(fileIds) != 3 {
+		t.Fatal(len(messages))
+	}
+	if messages[0].ThreadTs != "" || len(messages[0].Attachments) != 1 || fileIds[0][0] != "new" {
+		t.Error(messages[0], fileIds[0])
+	}
+	if messages[1].ThreadTs != "1425.01" || messages[1].ReplyBroadcast || fileIds[1][0] != "known" {
+		t.Error(messages[1], fileIds[1])
+	}
+	if messages[2].ThreadTs != "1425.01" || !messages[2].ReplyBroadcast || fileIds[2][0] != "deleted" {
+		t.Error(messages[2], fileIds[2])
+	}
+}
+
+func TestFileThreadsExpireAfterTheWindow(t *testing.T) {
+	now := time.Now()
+	conf := &FileThreadsConfiguration{Window: 60}
+	state := &UserState{}
+	state.rememberFileThreads("#general", []string{"old"}, "1.0", now.Add(-61*time.Minute))
+	state.rememberFileThreads("#general", []string{"recent"}, "2.0", now.Add(-59*time.Minute))
+	if _, found := state.fileThreadFor(conf, "#general", "old", now); found {
+		t.Error("expired thread found")
+	}
+	if ts, found := state.fileThreadFor(conf, "#general", "recent", now); !found || ts != "2.0" {
+		t.Error(ts)
+	}
+	if _, found := state.fileThreadFor(conf, "#design", "recent", now); found {
+		t.Error("threads are per channel")
+	}
+	state.expireFileThreads(conf, now)
+	if len(state.FileThreads) != 1 {
+		t.Error(state.FileThreads)
+	}
+}
+
+func TestFileThreadsAreNotUsedUnlessConfigured(t *testing.T) {
+	now := time.Now()
+	state := &UserState{Gdrive: &drive.State{ChangeSet: []drive.ChangeItem{aChange("known", "mockups", drive.Modified)}}}
+	state.rememberFileThreads("#general", []string{"known"}, "1425.01", now)
+	messages, _ := CreateSlackMessages(aSubscription(), state, routingFolders, nil, "test", now)
+	if len(messages) != 1 || messages[0].ThreadTs != "" {
+		t.Error(messages)
+	}
+	state.expireFileThreads(nil, now)
+	if state.FileThreads != nil {
+		t.Fail()
+	}
+}
+
+func TestFileIdsFollowTheSplitOfBlockMessages(t *testing.T) {
+	subscription := aSubscription()
+	subscription.Renderer = BlocksRenderer
+	state := &UserState{Gdrive: &drive.State{ChangeSet: syntheticChanges(100)}}
+	messages, fileIds := CreateSlackMessages(subscription, state, routingFolders, nil, "test", time.Now())
+	total := 0
+	for i, message := range messages {
+		blocks := len(message.Blocks)
+		if i == 0 {
+			blocks--
+		}
+		if len(fileIds[i]) != blocks/3 || fileIds[i][0] != "file-"+strconv.Itoa(total) {
+			t.Error(i, len(fileIds[i]), blocks)
+		}
+		total += len(fileIds[i])
+	}
+	if total != 100 {
+		t.Error(total)
+	}
+}
+
+func TestPostedMessagesStartFileThreads(t *testing.T) {
+	fake := &fakeSlack{responses: []fakeSlackResponse{slackOk}}
+	subscription := aSubscription()
+	subscription.FileThreads = &FileThreadsConfiguration{Window: 60, BroadcastDeletes: true}
+	subscription.ThreadChunks = true
+	state := &UserState{}
+	env := anEnvironment(fake)
+	messages := []*slack.Message{{Channel: "#a"}, {Channel: "#a"}, {Channel: "#a", ThreadTs: "99.0"}}
+	enqueueMessages(subscription, state, messages, [][]string{{"first"}, {"second"}, {"reply"}})
+	deliverOutbox(env, subscription, state)
+	now := time.Now()
+	for fileId, expected := range map[string]string{"first": "1425.01", "second": "1425.01", "reply": "99.0"} {
+		if ts, found := state.fileThreadFor(subscription.FileThreads, "#a", fileId, now); !found || ts != expected {
+			t.Error(fileId, ts)
+		}
+	}
+	if fake.threads[2] != "99.0" {
+		t.Error(fake.threads)
+	}
+}
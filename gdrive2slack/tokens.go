@@ -0,0 +1,280 @@
+package gdrive2slack
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// Keys are base64 encoded 256 bit AES keys, given either inline or through a
+// file. Records sealed with one of the previous keys are re-encrypted with the
+// current key when the store is loaded.
+type EncryptionConfiguration struct {
+	Key              string   `json:"key"`
+	KeyFile          string   `json:"key_file"`
+	PreviousKeys     []string `json:"previous_keys"`
+	PreviousKeyFiles []string `json:"previous_key_files"`
+}
+
+const sealedTokenPrefix = "enc:v1:"
+
+var ErrMissingEncryptionKey = errors.New("the subscription store contains encrypted tokens but no encryption key is configured")
+
+type masterKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// TokenCipher implements envelope encryption: every token is encrypted with a
+// fresh data key, and the data key is encrypted with the master key.
+type TokenCipher struct {
+	current *masterKey
+	keys    map[string]*masterKey
+}
+
+func NewTokenCipher(conf *EncryptionConfiguration) (*TokenCipher, error) {
+	if conf == nil || (conf.Key == "" && conf.KeyFile == "") {
+		return nil, nil
+	}
+	current, err := readKey(conf.Key, conf.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	self := &TokenCipher{
+		current: current,
+		keys:    map[string]*masterKey{current.id: current},
+	}
+	for _, encoded := range conf.PreviousKeys {
+		key, err := readKey(encoded, "")
+		if err != nil {
+			return nil, err
+		}
+		self.keys[key.id] = key
+	}
+	for _, filename := range conf.PreviousKeyFiles {
+		key, err := readKey("", filename)
+		if err != nil {
+			return nil, err
+		}
+		self.keys[key.id] = key
+	}
+	return self, nil
+}
+
+func readKey(encoded string, filename string) (*masterKey, error) {
+	if encoded == "" {
+		content, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read encryption key file: %s", err)
+		}
+		encoded = string(content)
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %s", err)
+	}
+	if len(raw) != 32 {
+		return nil, fmt.Errorf("invalid encryption key: expected 32 bytes, got %d", len(raw))
+	}
+	aead, err := newAead(raw)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(raw)
+	return &masterKey{hex.EncodeToString(digest[:4]), aead}, nil
+}
+
+func newAead(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func sealWith(aead cipher.AEAD, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func openWith(aead cipher.AEAD, sealed []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("sealed token is too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+}
+
+func isSealedToken(token string) bool {
+	return strings.HasPrefix(token, sealedTokenPrefix)
+}
+
+// Encrypt yields enc:v1:<key id>:<encrypted data key>:<encrypted token>
+func (self *TokenCipher) Encrypt(token string) (string, error) {
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", err
+	}
+	wrappedKey, err := sealWith(self.current.aead, dataKey)
+	if err != nil {
+		return "", err
+	}
+	aead, err := newAead(dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := sealWith(aead, []byte(token))
+	if err != nil {
+		return "", err
+	}
+	return sealedTokenPrefix + self.current.id + ":" + base64.StdEncoding.EncodeToString(wrappedKey) + ":" + base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+func (self *TokenCipher) Decrypt(token string) (string, error) {
+	if !isSealedToken(token) {
+		return token, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(token, sealedTokenPrefix), ":")
+	if len(parts) != 3 {
+		return "", errors.New("malformed encrypted token")
+	}
+	key, found := self.keys[parts[0]]
+	if !found {
+		return "", fmt.Errorf("token encrypted with unknown key '%s'", parts[0])
+	}
+	wrappedKey, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := openWith(key.aead, wrappedKey)
+	if err != nil {
+		return "", fmt.Errorf("cannot decrypt data key: %s", err)
+	}
+	aead, err := newAead(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := openWith(aead, ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("cannot decrypt token: %s", err)
+	}
+	return string(plaintext), nil
+}
+
+// NeedsRotation is true for clear text tokens and for tokens sealed with a previous key.
+func (self *TokenCipher) NeedsRotation(token string) bool {
+	return !strings.HasPrefix(token, sealedTokenPrefix+self.current.id+":")
+}
+
+// EncryptingStore seals the oauth tokens of every subscription before handing
+// it to the underlying store. Without a cipher tokens are stored in clear text.
+type EncryptingStore struct {
+	inner  SubscriptionStore
+	cipher *TokenCipher
+}
+
+func NewEncryptingStore(inner SubscriptionStore, cipher *TokenCipher) *EncryptingStore {
+	return &EncryptingStore{inner, cipher}
+}
+
+func (self *EncryptingStore) Load() (map[string]*Subscription, map[string]*UserState, error) {
+	sealedInfo, states, err := self.inner.Load()
+	if err != nil {
+		return nil, nil, err
+	}
+	info := make(map[string]*Subscription, len(sealedInfo))
+	toRotate := make(map[string]*Subscription)
+	for k, sealed := range sealedInfo {
+		if self.cipher == nil {
+			if isSealedToken(sealed.SlackAccessToken) || isSealedToken(sealed.GoogleRefreshToken) {
+				return nil, nil, ErrMissingEncryptionKey
+			}
+			info[k] = sealed
+			continue
+		}
+		opened := *sealed
+		if opened.SlackAccessToken, err = self.cipher.Decrypt(sealed.SlackAccessToken); err != nil {
+			return nil, nil, fmt.Errorf("subscription %s: %s", k, err)
+		}
+		if opened.GoogleRefreshToken, err = self.cipher.Decrypt(sealed.GoogleRefreshToken); err != nil {
+			return nil, nil, fmt.Errorf("subscription %s: %s", k, err)
+		}
+		info[k] = &opened
+		if self.cipher.NeedsRotation(sealed.SlackAccessToken) || self.cipher.NeedsRotation(sealed.GoogleRefreshToken) {
+			toRotate[k] = &opened
+		}
+	}
+	if len(toRotate) != 0 {
+		resealed := make(map[string]*Subscription, len(toRotate))
+		for k, subscription := range toRotate {
+			if resealed[k], err = self.seal(subscription); err != nil {
+				return nil, nil, err
+			}
+		}
+		if err = self.inner.UpsertAll(resealed, nil); err != nil {
+			return nil, nil, fmt.Errorf("cannot re-encrypt tokens: %s", err)
+		}
+	}
+	return info, states, nil
+}
+
+func (self *EncryptingStore) seal(subscription *Subscription) (*Subscription, error) {
+	if self.cipher == nil {
+		return subscription, nil
+	}
+	var err error
+	sealed := *subscription
+	if sealed.SlackAccessToken, err = self.cipher.Encrypt(subscription.SlackAccessToken); err != nil {
+		return nil, err
+	}
+	if sealed.GoogleRefreshToken, err = self.cipher.Encrypt(subscription.GoogleRefreshToken); err != nil {
+		return nil, err
+	}
+	return &sealed, nil
+}
+
+func (self *EncryptingStore) Upsert(key string, subscription *Subscription, state *UserState) error {
+	sealed, err := self.seal(subscription)
+	if err != nil {
+		return err
+	}
+	return self.inner.Upsert(key, sealed, state)
+}
+
+func (self *EncryptingStore) UpsertAll(info map[string]*Subscription, states map[string]*UserState) error {
+	sealedInfo := make(map[string]*Subscription, len(info))
+	for k, subscription := range info {
+		sealed, err := self.seal(subscription)
+		if err != nil {
+			return err
+		}
+		sealedInfo[k] = sealed
+	}
+	return self.inner.UpsertAll(sealedInfo, states)
+}
+
+func (self *EncryptingStore) Delete(keys ...string) error {
+	return self.inner.Delete(keys...)
+}
+
+func (self *EncryptingStore) UpdateStates(states map[string]*UserState) error {
+	return self.inner.UpdateStates(states)
+}
+
+func (self *EncryptingStore) Close() error {
+	return self.inner.Close()
+}
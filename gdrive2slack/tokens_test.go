@@ -0,0 +1,76 @@
+package gdrive2slack
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+const (
+	aKey       = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+	anotherKey = "ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA="
+)
+
+func TestEncryptedTokensCanBeDecrypted(t *testing.T) {
+	cipher, _ := NewTokenCipher(&EncryptionConfiguration{Key: aKey})
+	sealed, err := cipher.Encrypt("a-token")
+	if err != nil || sealed == "a-token" || !isSealedToken(sealed) {
+		t.Fatal(sealed, err)
+	}
+	if opened, err := cipher.Decrypt(sealed); err != nil || opened != "a-token" {
+		t.Fail()
+	}
+}
+
+func TestDecryptingWithAnUnknownKeyFails(t *testing.T) {
+	cipher, _ := NewTokenCipher(&EncryptionConfiguration{Key: aKey})
+	other, _ := NewTokenCipher(&EncryptionConfiguration{Key: anotherKey})
+	sealed, _ := cipher.Encrypt("a-token")
+	if _, err := other.Decrypt(sealed); err == nil {
+		t.Fail()
+	}
+}
+
+func TestTokensAreNotStoredInClearText(t *testing.T) {
+	defer cleanup(t, "/tmp", "temp-enc-subs*")
+	cipher, _ := NewTokenCipher(&EncryptionConfiguration{Key: aKey})
+	subs, _ := LoadSubscriptions(NewEncryptingStore(NewJsonStore("/tmp/temp-enc-subs"), cipher))
+	subscription := aSubscription()
+	subs.Add(subscription, "a-fake-token")
+	content, _ := ioutil.ReadFile("/tmp/temp-enc-subs")
+	if strings.Contains(string(content), "slack-token") || strings.Contains(string(content), "g-refresh-token") {
+		t.Fail()
+	}
+	deserialized, err := LoadSubscriptions(NewEncryptingStore(NewJsonStore("/tmp/temp-enc-subs"), cipher))
+	if err != nil || deserialized.Info[subscription.Id].SlackAccessToken != "slack-token" {
+		t.Fail()
+	}
+}
+
+func TestLoadingEncryptedTokensWithoutAKeyFails(t *testing.T) {
+	defer cleanup(t, "/tmp", "temp-enc-subs*")
+	cipher, _ := NewTokenCipher(&EncryptionConfiguration{Key: aKey})
+	subs, _ := LoadSubscriptions(NewEncryptingStore(NewJsonStore("/tmp/temp-enc-subs"), cipher))
+	subs.Add(aSubscription(), "a-fake-token")
+	if _, err := LoadSubscriptions(NewEncryptingStore(NewJsonStore("/tmp/temp-enc-subs"), nil)); err != ErrMissingEncryptionKey {
+		t.Fail()
+	}
+}
+
+func TestLoadingRotatesTokensToTheCurrentKey(t *testing.T) {
+	defer cleanup(t, "/tmp", "temp-enc-subs*")
+	old, _ := NewTokenCipher(&EncryptionConfiguration{Key: aKey})
+	subs, _ := LoadSubscriptions(NewEncryptingStore(NewJsonStore("/tmp/temp-enc-subs"), old))
+	subscription := aSubscription()
+	subs.Add(subscription, "a-fake-token")
+
+	rotated, _ := NewTokenCipher(&EncryptionConfiguration{Key: anotherKey, PreviousKeys: []string{aKey}})
+	if _, err := LoadSubscriptions(NewEncryptingStore(NewJsonStore("/tmp/temp-enc-subs"), rotated)); err != nil {
+		t.Fatal(err)
+	}
+	current, _ := NewTokenCipher(&EncryptionConfiguration{Key: anotherKey})
+	deserialized, err := LoadSubscriptions(NewEncryptingStore(NewJsonStore("/tmp/temp-enc-subs"), current))
+	if err != nil || deserialized.Info[subscription.Id].GoogleRefreshToken != "g-refresh-token" {
+		t.Fail()
+	}
+}
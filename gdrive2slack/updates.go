@@ -0,0 +1,153 @@
+package gdrive2slack
+
+import (
+	"fmt"
+	"github.com/optionfactory/gdrive2slack/google/drive"
+	"github.com/optionfactory/gdrive2slack/slack"
+	"strings"
+	"time"
+)
+
+// changes to a file within this window since the previous one update its
+// message, as long as the grace period of drive
+const fileMessagesWindow = time.Hour
+
+// FileMessage is the message notifying the changes to a file in a channel
+// when messages are updated in place. Posted is nil until it is delivered.
+type FileMessage struct {
+	Posted    *slack.PostedMessage `json:"posted"`
+	Editors   []string             `json:"editors"`
+	ChangedAt time.Time            `json:"changed_at"`
+}
+
+// expireFileMessages forgets the messages which are not updated anymore
+func (self *UserState) expireFileMessages(updateInPlace bool, now time.Time) {
+	if !updateInPlace {
+		self.FileMessages = nil
+		return
+	}
+	for key, message := range self.FileMessages {
+		if !now.Before(message.ChangedAt.Add(fileMessagesWindow)) {
+			delete(self.FileMessages, key)
+		}
+	}
+}
+
+// rememberFileMessages records where the message about the files was posted
+func (self *UserState) rememberFileMessages(channel string, fileIds []string, posted *slack.PostedMessage) {
+	for _, fileId := range fileIds {
+		if message, found := self.FileMessages[fileThreadKey(channel, fileId)]; found {
+			message.Posted = posted
+		}
+	}
+}
+
+// forgetFileMessages lets the next change to the files post a new message
+func (self *UserState) forgetFileMessages(channel string, fileIds []string) {
+	for _, fileId := range fileIds {
+		delete(self.FileMessages, fileThreadKey(channel, fileId))
+	}
+}
+
+// pendingFileEntry returns the last of entries posting a new message about
+// the file in channel, nil if there is none
+func pendingFileEntry(entries []*OutboxEntry, channel string, fileId string) *OutboxEntry {
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if entry.Update == nil && entry.Message.Channel == channel && len(entry.FileIds) == 1 && entry.FileIds[0] == fileId {
+			return entry
+		}
+	}
+	return nil
+}
+
+func appendEditor(editors []string, editor string) []string {
+	for _, e := range editors {
+		if e == editor {
+			return editors
+		}
+	}
+	return append(editors, editor)
+}
+
+// slackDate is shown in the time zone of the reader
+func slackDate(at time.Time) string {
+	return fmt.Sprintf("<!date^%d^{date_short_pretty} {time}|%s>", at.Unix(), at.UTC().Format("2006-01-02 15:04 UTC"))
+}
+
+// createFileMessage renders a change along with every editor of the file
+// since its message was first posted
+func createFileMessage(subscription *Subscription, prototype slack.Message, change *drive.ChangeItem, editors []string, at time.Time, folders *drive.Folders) *slack.Message {
+	message := prototype
+	editorsTitle := "Editor"
+	if len(editors) > 1 {
+		editorsTitle = "Editors"
+	}
+	if subscription.Renderer == BlocksRenderer {
+		message.Blocks = withMuteActions(subscription, change, []slack.Block{
+			CreateSlackBlocks(change, folders, nil)[0],
+			slack.NewContextBlock(
+				fmt.Sprintf("%s: %s", editorsTitle, strings.Join(editors, ", ")),
+				fmt.Sprintf("Folder: %s", folderOf(change, folders)),
+				fmt.Sprintf("Last change: %s", slackDate(at)),
+			),
+		})
+		return &message
+	}
+	attachment := CreateSlackAttachment(change, nil)
+	attachment.Fields[1] = slack.Field{Title: editorsTitle, Value: strings.Join(editors, ", "), Short: true}
+	attachment.Fields = append(attachment.Fields, slack.Field{Title: "Last change", Value: slackDate(at), Short: true})
+	message.Attachments = []slack.Attachment{*attachment}
+	return &message
+}
+
+// CreateSlackFileEntries yields a message of its own for every change routed
+// to a channel. Changes to a file notified in the channel within the window
+// update the earlier message instead, listing every editor since.
+func CreateSlackFileEntries(subscription *Subscription, userState *UserState, folders *drive.Folders, editors map[string]string, version string, now time.Time) []*OutboxEntry {
+	changes := UnmutedChanges(subscription.Mutes, FilterChanges(subscription.Rules, userState.Gdrive.ChangeSet, folders), folders, now)
+	channels, routed := RouteChanges(subscription, changes, folders)
+	if userState.FileMessages == nil {
+		userState.FileMessages = make(map[string]*FileMessage)
+	}
+	entries := make([]*OutboxEntry, 0, len(changes))
+	for _, channel := range channels {
+		prototype := activityPrototype(subscription, userState, channel, version)
+		for _, change := range routed[channel] {
+			editor := formatEditor(change, editors)
+			key := fileThreadKey(channel, change.FileId)
+			if fileMessage, found := userState.FileMessages[key]; found && fileMessage.Posted != nil {
+				fileMessage.Editors = appendEditor(fileMessage.Editors, editor)
+				fileMessage.ChangedAt = now
+				entries = append(entries, &OutboxEntry{
+					Message: createFileMessage(subscription, prototype, change, fileMessage.Editors, now, folders),
+					FileIds: []string{change.FileId},
+					Update:  fileMessage.Posted,
+				})
+				continue
+			}
+			if fileMessage, found := userState.FileMessages[key]; found {
+				// the message is still waiting to be posted: it is rewritten
+				// instead of posting another one about the same file
+				pending := pendingFileEntry(entries, channel, change.FileId)
+				if pending == nil {
+					pending = pendingFileEntry(userState.Outbox, channel, change.FileId)
+				}
+				if pending != nil {
+					fileMessage.Editors = appendEditor(fileMessage.Editors, editor)
+					fileMessage.ChangedAt = now
+					pending.Message = createFileMessage(subscription, prototype, change, fileMessage.Editors, now, folders)
+					continue
+				}
+			}
+			if change.FileId != "" {
+				userState.FileMessages[key] = &FileMessage{Editors: []string{editor}, ChangedAt: now}
+			}
+			entries = append(entries, &OutboxEntry{
+				Message: createFileMessage(subscription, prototype, change, []string{editor}, now, folders),
+				FileIds: []string{change.FileId},
+			})
+		}
+	}
+	return entries
+}
@@ -0,0 +1,111 @@
+package gdrive2slack
+
+import (
+	"github.com/optionfactory/gdrive2slack/google/drive"
+	"github.com/optionfactory/gdrive2slack/slack"
+	"testing"
+	"time"
+)
+
+func TestEveryChangedFileGetsAMessageOfItsOwn(t *testing.T) {
+	subscription := aSubscription()
+	subscription.UpdateInPlace = true
+	state := &UserState{Gdrive: &drive.State{ChangeSet: []drive.ChangeItem{aChange("a", "mockups", drive.Modified), aChange("b", "mockups", drive.Modified)}}}
+	entries := CreateSlackFileEntries(subscription, state, routingFolders, nil, "test", time.Now())
+	if len(entries) != 2 || entries[0].Update != nil || entries[0].FileIds[0] != "a" || len(entries[0].Message.Attachments) != 1 {
+		t.Fatal(entries)
+	}
+	if state.FileMessages[fileThreadKey("#general", "a")].Posted != nil {
+		t.Error("not posted yet")
+	}
+}
+
+func TestChangesToAPostedFileUpdateItsMessage(t *testing.T) {
+	now := time.Now()
+	subscription := aSubscription()
+	subscription.UpdateInPlace = true
+	byJane, byJohn := aChange("a", "mockups", drive.Modified), aChange("a", "mockups", drive.Modified)
+	byJane.File.LastModifyingUser.DisplayName = "jane"
+	byJohn.File.LastModifyingUser.DisplayName = "john"
+	state := &UserState{Gdrive: &drive.State{ChangeSet: []drive.ChangeItem{byJane}}}
+	CreateSlackFileEntries(subscription, state, routingFolders, nil, "test", now.Add(-time.Minute))
+	state.rememberFileMessages("#general", []string{"a"}, &slack.PostedMessage{Channel: "C0123", Ts: "1425.01"})
+	state.Gdrive.ChangeSet = []drive.ChangeItem{byJohn, byJane}
+	entries := CreateSlackFileEntries(subscription, state, routingFolders, nil, "test", now)
+	last := entries[len(entries)-1]
+	if last.Update == nil || last.Update.Ts != "1425.01" {
+		t.Fatal(last)
+	}
+	if editors := last.Message.Attachments[0].Fields[1]; editors.Title != "Editors" || editors.Value != "j\u200bane, j\u200bohn" {
+		t.Error(editors)
+	}
+	if !state.FileMessages[fileThreadKey("#general", "a")].ChangedAt.Equal(now) {
+		t.Error("change time not refreshed")
+	}
+}
+
+func TestFileMessagesExpireAfterTheWindow(t *testing.T) {
+	now := time.Now()
+	state := &UserState{FileMessages: map[string]*FileMessage{
+		"#general|old":    {ChangedAt: now.Add(-fileMessagesWindow)},
+		"#general|recent": {ChangedAt: now.Add(-time.Minute)},
+	}}
+	state.expireFileMessages(true, now)
+	if len(state.FileMessages) != 1 || state.FileMessages["#general|recent"] == nil {
+		t.Error(state.FileMessages)
+	}
+	state.expireFileMessages(false, now)
+	if state.FileMessages != nil {
+		t.Fail()
+	}
+}
+
+func TestDeliveredFileMessagesCanBeUpdated(t *testing.T) {
+	fake := &fakeSlack{responses: []fakeSlackResponse{slackOk}}
+	subscription := aSubscription()
+	subscription.UpdateInPlace = true
+	state := &UserState{Gdrive: &drive.State{ChangeSet: []drive.ChangeItem{aChange("a", "mockups", drive.Modified)}}}
+	env := anEnvironment(fake)
+	enqueueEntries(state, CreateSlackFileEntries(subscription, state, routingFolders, nil, "test", time.Now()))
+	deliverOutbox(env, subscription, state)
+	state.Gdrive.ChangeSet = []drive.ChangeItem{aChange("a", "mockups", drive.Modified)}
+	enqueueEntries(state, CreateSlackFileEntries(subscription, state, routingFolders, nil, "test", time.Now()))
+	deliverOutbox(env, subscription, state)
+	if len(fake.posted) != 2 || fake.posted[0] != "#general" || fake.posted[1] != "C0123" {
+		t.Error(fake.posted)
+	}
+}
+
+func TestFailedUpdatesLetTheNextChangePostANewMessage(t *testing.T) {
+	fake := &fakeSlack{responses: []fakeSlackResponse{{200, "", `{"ok":false,"error":"message_not_found"}`}}}
+	subscription := aSubscription()
+	subscription.UpdateInPlace = true
+	state := &UserState{FileMessages: map[string]*FileMessage{
+		"#general|a": {Posted: &slack.PostedMessage{Channel: "C0123", Ts: "1425.01"}, ChangedAt: time.Now()},
+	}}
+	state.Outbox = []*OutboxEntry{{Message: &slack.Message{Channel: "#general"}, FileIds: []string{"a"}, Update: state.FileMessages["#general|a"].Posted}}
+	deliverOutbox(anEnvironment(fake), subscription, state)
+	if len(state.Outbox) != 0 || len(state.FileMessages) != 0 {
+		t.Error(state.FileMessages)
+	}
+}
+
+func TestChangesToAFileWaitingToBePostedRewriteItsMessage(t *testing.T) {
+	subscription := aSubscription()
+	subscription.UpdateInPlace = true
+	byJane, byJohn := aChange("a", "mockups", drive.Modified), aChange("a", "mockups", drive.Modified)
+	byJane.File.LastModifyingUser.DisplayName = "jane"
+	byJohn.File.LastModifyingUser.DisplayName = "john"
+	state := &UserState{Gdrive: &drive.State{ChangeSet: []drive.ChangeItem{byJane}}}
+	enqueueEntries(state, CreateSlackFileEntries(subscription, state, routingFolders, nil, "test", time.Now()))
+	state.Gdrive.ChangeSet = []drive.ChangeItem{byJohn}
+	if entries := CreateSlackFileEntries(subscription, state, routingFolders, nil, "test", time.Now()); len(entries) != 0 {
+		t.Fatal(entries)
+	}
+	if len(state.Outbox) != 1 {
+		t.Fatal(state.Outbox)
+	}
+	if editors := state.Outbox[0].Message.Attachments[0].Fields[1]; editors.Title != "Editors" || editors.Value != "j\u200bane, j\u200bohn" {
+		t.Error(editors)
+	}
+}
@@ -0,0 +1,22 @@
+module github.com/optionfactory/gdrive2slack
+
+go 1.25.0
+
+require (
+	github.com/go-martini/martini v0.0.0-20170121215854-22fa46961aab
+	github.com/martini-contrib/render v0.0.0-20150707142108-ec18f8345a11
+	github.com/prometheus/client_golang v1.19.0
+	go.etcd.io/bbolt v1.5.0
+)
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/codegangsta/inject v0.0.0-20150114235600-33e0aa1cb7c0 // indirect
+	github.com/oxtoacart/bpool v0.0.0-20190530202638-03653db5a59c // indirect
+	github.com/prometheus/client_model v0.5.0 // indirect
+	github.com/prometheus/common v0.48.0 // indirect
+	github.com/prometheus/procfs v0.12.0 // indirect
+	golang.org/x/sys v0.45.0 // indirect
+	google.golang.org/protobuf v1.32.0 // indirect
+)
@@ -13,13 +13,20 @@ import (
 )
 
 type changes struct {
-	LargestChangeId string                `json:"largestChangeId"`
-	Items           []ChangeItem          `json:"items"`
-	Error           *google.ErrorResponse `json:"error"`
+	NextPageToken     string                `json:"nextPageToken"`
+	NewStartPageToken string                `json:"newStartPageToken"`
+	Items             []ChangeItem          `json:"changes"`
+	Error             *google.ErrorResponse `json:"error"`
+}
+
+type startPageToken struct {
+	StartPageToken string                `json:"startPageToken"`
+	Error          *google.ErrorResponse `json:"error"`
 }
 
 type ChangeItem struct {
-	Deleted    bool        `json:"deleted"`
+	Deleted    bool        `json:"removed"`
+	FileId     string      `json:"fileId"`
 	LastAction Action      `json:"-"`
 	Type       ItemType    `json:"-"`
 	File       ChangedFile `json:"file"`
@@ -41,17 +48,83 @@ func (t ItemType) String() string {
 	return itemTypeNames[t]
 }
 
+// ChangedFile keeps the names used by the v2 api, the json form is the one of a v3 file
 type ChangedFile struct {
-	ExplicitlyTrashed bool             `json:"explicitlyTrashed"`
-	LastModifyingUser User             `json:"lastModifyingUser"`
-	AlternateLink     string           `json:"alternateLink"`
-	MimeType          string           `json:"mimeType"`
-	OwnerNames        []string         `json:"ownerNames"`
-	CreatedDate       google.Timestamp `json:"createdDate"`
-	ModifiedDate      google.Timestamp `json:"modifiedDate"`
-	SharedWithMeDate  google.Timestamp `json:"sharedWithMeDate"`
-	Title             string           `json:"title"`
-	Parents           []Parent         `json:"parents"`
+	ExplicitlyTrashed bool
+	LastModifyingUser User
+	AlternateLink     string
+	MimeType          string
+	OwnerNames        []string
+	CreatedDate       google.Timestamp
+	ModifiedDate      google.Timestamp
+	SharedWithMeDate  google.Timestamp
+	Title             string
+	Parents           []Parent
+}
+
+type file struct {
+	ExplicitlyTrashed bool              `json:"explicitlyTrashed"`
+	LastModifyingUser User              `json:"lastModifyingUser"`
+	WebViewLink       string            `json:"webViewLink"`
+	MimeType          string            `json:"mimeType"`
+	Owners            []User            `json:"owners"`
+	CreatedTime       google.Timestamp  `json:"createdTime"`
+	ModifiedTime      google.Timestamp  `json:"modifiedTime"`
+	SharedWithMeTime  *google.Timestamp `json:"sharedWithMeTime,omitempty"`
+	Name              string            `json:"name"`
+	Parents           []string          `json:"parents"`
+}
+
+func (self *ChangedFile) UnmarshalJSON(b []byte) error {
+	var f file
+	if err := json.Unmarshal(b, &f); err != nil {
+		return err
+	}
+	*self = ChangedFile{
+		ExplicitlyTrashed: f.ExplicitlyTrashed,
+		LastModifyingUser: f.LastModifyingUser,
+		AlternateLink:     f.WebViewLink,
+		MimeType:          f.MimeType,
+		OwnerNames:        make([]string, 0, len(f.Owners)),
+		CreatedDate:       f.CreatedTime,
+		ModifiedDate:      f.ModifiedTime,
+		Title:             f.Name,
+		Parents:           make([]Parent, 0, len(f.Parents)),
+	}
+	if f.SharedWithMeTime != nil {
+		self.SharedWithMeDate = *f.SharedWithMeTime
+	}
+	for _, owner := range f.Owners {
+		self.OwnerNames = append(self.OwnerNames, owner.DisplayName)
+	}
+	for _, parent := range f.Parents {
+		self.Parents = append(self.Parents, Parent{parent})
+	}
+	return nil
+}
+
+func (self ChangedFile) MarshalJSON() ([]byte, error) {
+	f := file{
+		ExplicitlyTrashed: self.ExplicitlyTrashed,
+		LastModifyingUser: self.LastModifyingUser,
+		WebViewLink:       self.AlternateLink,
+		MimeType:          self.MimeType,
+		Owners:            make([]User, 0, len(self.OwnerNames)),
+		CreatedTime:       self.CreatedDate,
+		ModifiedTime:      self.ModifiedDate,
+		Name:              self.Title,
+		Parents:           make([]string, 0, len(self.Parents)),
+	}
+	if !self.SharedWithMeDate.IsZero() {
+		f.SharedWithMeTime = &self.SharedWithMeDate
+	}
+	for _, owner := range self.OwnerNames {
+		f.Owners = append(f.Owners, User{DisplayName: owner})
+	}
+	for _, parent := range self.Parents {
+		f.Parents = append(f.Parents, parent.Id)
+	}
+	return json.Marshal(&f)
 }
 
 type Action int
@@ -78,7 +151,7 @@ func (t Action) String() string {
 
 func (t *ChangeItem) updateLastAction(timeRef time.Time) {
 	var f = t.File
-	var threshold = google.Timestamp{timeRef.Add(-time.Duration(10) * time.Minute)}
+	var threshold = google.Timestamp{Time: timeRef.Add(-time.Duration(10) * time.Minute)}
 	if t.Deleted || f.ExplicitlyTrashed {
 		t.LastAction = Deleted
 		return
@@ -108,7 +181,7 @@ func (t *ChangeItem) updateType() {
 
 type User struct {
 	EmailAddress string `json:"emailAddress"`
-	DisplayName  string `json:"displayName`
+	DisplayName  string `json:"displayName"`
 }
 
 type GracePeriodKey struct {
@@ -116,37 +189,132 @@ type GracePeriodKey struct {
 	LastModifyingUserEmail string
 }
 
+// PageToken is the cursor of My Drive, shared drives have a cursor each
 type State struct {
-	LargestChangeId uint64
+	PageToken       string
+	DrivePageTokens map[string]string
 	InGracePeriod   map[GracePeriodKey]time.Time
 	ChangeSet       []ChangeItem
+	// Omitted counts the changes past the per-poll cap. They are counted
+	// before the rules and mutes of the subscription are applied, so some of
+	// them might never have been notified.
+	Omitted int
 }
 
 func NewState() *State {
 	return &State{
-		InGracePeriod: make(map[GracePeriodKey]time.Time),
+		DrivePageTokens: make(map[string]string),
+		InGracePeriod:   make(map[GracePeriodKey]time.Time),
 	}
 }
 
-func query(client *http.Client, state *State, accessToken string) (google.StatusCode, error) {
-	var timeRef = time.Now()
-	u, _ := url.Parse("https://www.googleapis.com/drive/v2/changes")
+type gracePeriodEntry struct {
+	FileTitle              string    `json:"file_title"`
+	LastModifyingUserEmail string    `json:"last_modifying_user_email"`
+	NotifiedAt             time.Time `json:"notified_at"`
+}
+
+// the change set is transient: only the cursor and the grace period survive a restart
+type persistentState struct {
+	PageToken       string             `json:"page_token"`
+	DrivePageTokens map[string]string  `json:"drive_page_tokens"`
+	LargestChangeId uint64             `json:"largest_change_id,omitempty"` // v2 cursor, only read to migrate it
+	InGracePeriod   []gracePeriodEntry `json:"in_grace_period"`
+}
+
+func (self *State) MarshalJSON() ([]byte, error) {
+	p := &persistentState{
+		PageToken:       self.PageToken,
+		DrivePageTokens: self.DrivePageTokens,
+		InGracePeriod:   make([]gracePeriodEntry, 0, len(self.InGracePeriod)),
+	}
+	for k, at := range self.InGracePeriod {
+		p.InGracePeriod = append(p.InGracePeriod, gracePeriodEntry{k.FileTitle, k.LastModifyingUserEmail, at})
+	}
+	return json.Marshal(p)
+}
+
+func (self *State) UnmarshalJSON(b []byte) error {
+	var p persistentState
+	if err := json.Unmarshal(b, &p); err != nil {
+		return err
+	}
+	self.PageToken = p.PageToken
+	// v2 cursors pointed to the last seen change, v3 page tokens to the next one
+	if self.PageToken == "" && p.LargestChangeId != 0 {
+		self.PageToken = strconv.FormatUint(p.LargestChangeId+1, 10)
+	}
+	self.DrivePageTokens = p.DrivePageTokens
+	if self.DrivePageTokens == nil {
+		self.DrivePageTokens = make(map[string]string)
+	}
+	self.InGracePeriod = make(map[GracePeriodKey]time.Time)
+	for _, e := range p.InGracePeriod {
+		self.InGracePeriod[GracePeriodKey{e.FileTitle, e.LastModifyingUserEmail}] = e.NotifiedAt
+	}
+	self.ChangeSet = nil
+	return nil
+}
+
+var (
+	changesUrl        = "https://www.googleapis.com/drive/v3/changes"
+	startPageTokenUrl = "https://www.googleapis.com/drive/v3/changes/startPageToken"
+)
+
+const changesFields = "nextPageToken,newStartPageToken,changes(removed,fileId,file(parents,explicitlyTrashed,webViewLink,mimeType,createdTime,modifiedTime,sharedWithMeTime,name,owners(displayName),lastModifyingUser(displayName,emailAddress)))"
+
+func fetchStartPageToken(client *http.Client, accessToken string, driveId string) (google.StatusCode, error, string) {
+	u, _ := url.Parse(startPageTokenUrl)
+	if driveId != "" {
+		q := u.Query()
+		q.Set("driveId", driveId)
+		q.Set("supportsAllDrives", "true")
+		u.RawQuery = q.Encode()
+	}
+	req, _ := http.NewRequest("GET", u.String(), nil)
+	req.Header.Add("Authorization", "Bearer "+accessToken)
+	response, err := client.Do(req)
+	if err != nil {
+		return google.CannotConnect, err, ""
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	var token = new(startPageToken)
+	err = json.Unmarshal(body, &token)
+
+	if err != nil {
+		return google.CannotDeserialize, err, ""
+	}
+	if token.Error != nil {
+		if token.Error.Code == 401 {
+			return google.Unauthorized, errors.New(token.Error.Message), ""
+		}
+		return google.ApiError, errors.New(token.Error.Message), ""
+	}
+	return google.Ok, nil, token.StartPageToken
+}
+
+// changes to My Drive are fetched when driveId is empty
+func fetchChangesPage(client *http.Client, accessToken string, driveId string, pageToken string) (google.StatusCode, error, *changes) {
+	u, _ := url.Parse(changesUrl)
 	q := u.Query()
-	if state.LargestChangeId == 0 {
-		q.Set("fields", "largestChangeId")
+	q.Set("fields", changesFields)
+	q.Set("pageToken", pageToken)
+	q.Set("includeRemoved", "true")
+	q.Set("supportsAllDrives", "true")
+	if driveId != "" {
+		q.Set("driveId", driveId)
+		q.Set("includeItemsFromAllDrives", "true")
 	} else {
-		q.Set("fields", "largestChangeId,items(deleted,file(parents(id),explicitlyTrashed,alternateLink,mimeType,createdDate,modifiedDate,sharedWithMeDate,title,ownerNames,lastModifyingUser(displayName,emailAddress)))")
-		q.Set("startChangeId", strconv.FormatUint(state.LargestChangeId+1, 10))
+		q.Set("restrictToMyDrive", "true")
 	}
-	q.Set("includeDeleted", "true")
-	q.Set("includeSubscribed", "false")
-	q.Set("maxResults", "100")
+	q.Set("pageSize", "100")
 	u.RawQuery = q.Encode()
 	req, _ := http.NewRequest("GET", u.String(), nil)
 	req.Header.Add("Authorization", "Bearer "+accessToken)
 	response, err := client.Do(req)
 	if err != nil {
-		return google.CannotConnect, err
+		return google.CannotConnect, err, nil
 	}
 	defer response.Body.Close()
 	body, err := ioutil.ReadAll(response.Body)
@@ -154,42 +322,129 @@ func query(client *http.Client, state *State, accessToken string) (google.Status
 	err = json.Unmarshal(body, &changes)
 
 	if err != nil {
-		return google.CannotDeserialize, err
+		return google.CannotDeserialize, err, nil
 	}
 	if changes.Error != nil {
 		if changes.Error.Code == 401 {
-			return google.Unauthorized, errors.New(changes.Error.Message)
+			return google.Unauthorized, errors.New(changes.Error.Message), nil
 		}
-		return google.ApiError, errors.New(changes.Error.Message)
+		return google.ApiError, errors.New(changes.Error.Message), nil
 	}
-	state.LargestChangeId, err = strconv.ParseUint(changes.LargestChangeId, 10, 64)
-	state.ChangeSet = make([]ChangeItem, 0, len(changes.Items))
+	return google.Ok, nil, changes
+}
+
+type changeSetBuilder struct {
+	timeRef      time.Time
+	threshold    time.Time
+	maxChanges   int
+	keepRepeated bool
+	state        *State
+	changeSet    []ChangeItem
+	omitted      int
+	notified     map[GracePeriodKey]time.Time
+}
 
-	if len(changes.Items) == 0 {
-		return google.Ok, nil
+func newChangeSetBuilder(state *State, maxChanges int, keepRepeated bool) *changeSetBuilder {
+	var timeRef = time.Now()
+	return &changeSetBuilder{
+		timeRef:      timeRef,
+		threshold:    timeRef.Add(time.Duration(-60) * time.Minute),
+		maxChanges:   maxChanges,
+		keepRepeated: keepRepeated,
+		state:        state,
+		changeSet:    make([]ChangeItem, 0),
+		notified:     make(map[GracePeriodKey]time.Time),
+	}
+}
+
+func (self *changeSetBuilder) add(item ChangeItem) {
+	item.updateLastAction(self.timeRef)
+	item.updateType()
+	if item.LastAction == Viewed || item.File.Title == "" {
+		return
 	}
-	var threshold = timeRef.Add(time.Duration(-60) * time.Minute)
-	for _, item := range changes.Items {
-		item.updateLastAction(timeRef)
-		item.updateType()
-		if item.LastAction == Viewed || item.File.Title == "" {
-			continue
+	if isTemporaryFile(item.File.Title) {
+		return
+	}
+	k := GracePeriodKey{item.File.Title, item.File.LastModifyingUser.EmailAddress}
+	notifiedAt, alreadyNotified := self.state.InGracePeriod[k]
+	if _, notifiedNow := self.notified[k]; notifiedNow && item.LastAction != Deleted {
+		return
+	}
+	if alreadyNotified && notifiedAt.After(self.threshold) && item.LastAction != Deleted && !self.keepRepeated {
+		return
+	}
+	if self.maxChanges > 0 && len(self.changeSet) >= self.maxChanges {
+		self.omitted++
+		return
+	}
+	self.notified[k] = self.timeRef
+	self.changeSet = append(self.changeSet, item)
+}
+
+// follow fetches every page of a drive, yielding the page token to start from next time
+func (self *changeSetBuilder) follow(client *http.Client, accessToken string, driveId string, pageToken string) (google.StatusCode, error, string) {
+	for {
+		statusCode, err, changes := fetchChangesPage(client, accessToken, driveId, pageToken)
+		if statusCode != google.Ok {
+			return statusCode, err, ""
 		}
-		if isTemporaryFile(item.File.Title) {
-			continue
+		for _, item := range changes.Items {
+			self.add(item)
 		}
-		k := GracePeriodKey{item.File.Title, item.File.LastModifyingUser.EmailAddress}
-		notifiedAt, alreadyNotified := state.InGracePeriod[k]
-		if !(alreadyNotified && notifiedAt.After(threshold) && item.LastAction != Deleted) {
-			state.InGracePeriod[k] = timeRef
-			state.ChangeSet = append(state.ChangeSet, item)
+		if changes.NextPageToken == "" {
+			return google.Ok, nil, changes.NewStartPageToken
+		}
+		pageToken = changes.NextPageToken
+	}
+}
+
+func (self *changeSetBuilder) commit(pageToken string, drivePageTokens map[string]string) {
+	self.state.PageToken = pageToken
+	self.state.DrivePageTokens = drivePageTokens
+	self.state.ChangeSet = self.changeSet
+	self.state.Omitted = self.omitted
+	for k, at := range self.notified {
+		self.state.InGracePeriod[k] = at
+	}
+	for k, at := range self.state.InGracePeriod {
+		if at.Before(self.threshold) {
+			delete(self.state.InGracePeriod, k)
 		}
 	}
-	for k, at := range state.InGracePeriod {
-		if at.Before(threshold) {
-			delete(state.InGracePeriod, k)
+}
+
+// query follows pagination of My Drive and of every shared drive until the
+// cursors are caught up: at most maxChanges changes are collected (all of
+// them when maxChanges <= 0), the others are only counted in state.Omitted,
+// whether or not they would pass the filters of the subscription.
+// Shared drives seen for the first time only get a cursor. Changes within the
+// grace period of an earlier one are dropped unless keepRepeated is set.
+// The state is left untouched when any page fails.
+func query(client *http.Client, state *State, accessToken string, maxChanges int, keepRepeated bool) (google.StatusCode, error) {
+	statusCode, err, sharedDrives := FetchSharedDrives(client, accessToken)
+	if statusCode != google.Ok {
+		return statusCode, err
+	}
+	builder := newChangeSetBuilder(state, maxChanges, keepRepeated)
+	statusCode, err, pageToken := builder.follow(client, accessToken, "", state.PageToken)
+	if statusCode != google.Ok {
+		return statusCode, err
+	}
+	drivePageTokens := make(map[string]string)
+	for _, sharedDrive := range sharedDrives {
+		drivePageToken, known := state.DrivePageTokens[sharedDrive.Id]
+		if known {
+			statusCode, err, drivePageToken = builder.follow(client, accessToken, sharedDrive.Id, drivePageToken)
+		} else {
+			statusCode, err, drivePageToken = fetchStartPageToken(client, accessToken, sharedDrive.Id)
+		}
+		if statusCode != google.Ok {
+			return statusCode, err
 		}
+		drivePageTokens[sharedDrive.Id] = drivePageToken
 	}
+	builder.commit(pageToken, drivePageTokens)
 	return google.Ok, nil
 }
 
@@ -206,10 +461,29 @@ func isTemporaryFile(title string) bool {
 	return false
 }
 
-func LargestChangeId(client *http.Client, state *State, accessToken string) (google.StatusCode, error) {
-	return query(client, state, accessToken)
+func StartPageToken(client *http.Client, state *State, accessToken string) (google.StatusCode, error) {
+	statusCode, err, sharedDrives := FetchSharedDrives(client, accessToken)
+	if statusCode != google.Ok {
+		return statusCode, err
+	}
+	drivePageTokens := make(map[string]string)
+	for _, sharedDrive := range sharedDrives {
+		statusCode, err, drivePageTokens[sharedDrive.Id] = fetchStartPageToken(client, accessToken, sharedDrive.Id)
+		if statusCode != google.Ok {
+			return statusCode, err
+		}
+	}
+	statusCode, err, token := fetchStartPageToken(client, accessToken, "")
+	if statusCode != google.Ok {
+		return statusCode, err
+	}
+	state.PageToken = token
+	state.DrivePageTokens = drivePageTokens
+	state.ChangeSet = make([]ChangeItem, 0)
+	state.Omitted = 0
+	return google.Ok, nil
 }
 
-func DetectChanges(client *http.Client, state *State, accessToken string) (google.StatusCode, error) {
-	return query(client, state, accessToken)
+func DetectChanges(client *http.Client, state *State, accessToken string, maxChanges int, keepRepeated bool) (google.StatusCode, error) {
+	return query(client, state, accessToken, maxChanges, keepRepeated)
 }
@@ -1,8 +1,14 @@
 package drive
 
 import (
+	"encoding/json"
+	"fmt"
+	"github.com/optionfactory/gdrive2slack/google"
 	_ "log"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func TestRegularOfficeFilesAreNotTemporary(t *testing.T) {
@@ -16,3 +22,264 @@ func TestCanDetectOfficeTemporaryFiles(t *testing.T) {
 		t.Fail()
 	}
 }
+
+type fakeChangesPage struct {
+	NextPageToken     string                   `json:"nextPageToken,omitempty"`
+	NewStartPageToken string                   `json:"newStartPageToken,omitempty"`
+	Changes           []map[string]interface{} `json:"changes"`
+}
+
+func modifiedFile(title string, at time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"fileId": "id-" + title,
+		"file": map[string]interface{}{
+			"name":         title,
+			"mimeType":     "application/vnd.google-apps.document",
+			"createdTime":  at.Add(-time.Hour).UTC().Format("2006-01-02T15:04:05.000Z"),
+			"modifiedTime": at.UTC().Format("2006-01-02T15:04:05.000Z"),
+			"owners":       []map[string]string{{"displayName": "owner"}},
+			"parents":      []string{"folder"},
+			"lastModifyingUser": map[string]interface{}{
+				"displayName":  "editor",
+				"emailAddress": "editor@example.com",
+			},
+		},
+	}
+}
+
+func writeSharedDrives(w http.ResponseWriter, sharedDrives ...string) {
+	response := map[string][]SharedDrive{"drives": make([]SharedDrive, 0)}
+	for _, id := range sharedDrives {
+		response["drives"] = append(response["drives"], SharedDrive{id, "drive " + id})
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// serves pages of 3 changes each, page tokens are "page-<index>".
+// every shared drive has a single change.
+func fakeChangesEndpoint(t *testing.T, newStartPageToken string, changes int, sharedDrives ...string) *httptest.Server {
+	now := time.Now()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		driveId := r.URL.Query().Get("driveId")
+		if r.URL.Path == "/drives" {
+			writeSharedDrives(w, sharedDrives...)
+			return
+		}
+		if r.URL.Path == "/startPageToken" && driveId != "" {
+			json.NewEncoder(w).Encode(map[string]string{"startPageToken": driveId + "-start"})
+			return
+		}
+		if r.URL.Path == "/startPageToken" {
+			json.NewEncoder(w).Encode(map[string]string{"startPageToken": newStartPageToken})
+			return
+		}
+		if driveId != "" {
+			json.NewEncoder(w).Encode(fakeChangesPage{
+				NewStartPageToken: driveId + "-next",
+				Changes:           []map[string]interface{}{modifiedFile("file-in-"+driveId, now)},
+			})
+			return
+		}
+		page := 0
+		if _, err := fmt.Sscanf(r.URL.Query().Get("pageToken"), "page-%d", &page); err != nil && r.URL.Query().Get("pageToken") != "start" {
+			t.Error("unexpected page token", r.URL.Query().Get("pageToken"))
+		}
+		response := fakeChangesPage{
+			Changes: make([]map[string]interface{}, 0),
+		}
+		for i := page * 3; i < changes && i < (page+1)*3; i++ {
+			response.Changes = append(response.Changes, modifiedFile(fmt.Sprintf("file-%d", i), now))
+		}
+		if (page+1)*3 < changes {
+			response.NextPageToken = fmt.Sprintf("page-%d", page+1)
+		} else {
+			response.NewStartPageToken = newStartPageToken
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+}
+
+func withFakeEndpoint(server *httptest.Server, f func()) {
+	defer func(changes string, startPageToken string, drives string) {
+		changesUrl = changes
+		startPageTokenUrl = startPageToken
+		drivesUrl = drives
+	}(changesUrl, startPageTokenUrl, drivesUrl)
+	changesUrl = server.URL
+	startPageTokenUrl = server.URL + "/startPageToken"
+	drivesUrl = server.URL + "/drives"
+	f()
+}
+
+func TestFirstQueryOnlyFetchesTheStartPageToken(t *testing.T) {
+	server := fakeChangesEndpoint(t, "42", 10)
+	defer server.Close()
+	state := NewState()
+	withFakeEndpoint(server, func() {
+		if status, err := StartPageToken(http.DefaultClient, state, "token"); status != google.Ok {
+			t.Fatal(err)
+		}
+	})
+	if state.PageToken != "42" || len(state.ChangeSet) != 0 {
+		t.Fail()
+	}
+}
+
+func TestDetectChangesFollowsEveryPage(t *testing.T) {
+	server := fakeChangesEndpoint(t, "42", 10)
+	defer server.Close()
+	state := NewState()
+	state.PageToken = "start"
+	withFakeEndpoint(server, func() {
+		if status, err := DetectChanges(http.DefaultClient, state, "token", 100, false); status != google.Ok {
+			t.Fatal(err)
+		}
+	})
+	if len(state.ChangeSet) != 10 || state.Omitted != 0 || state.PageToken != "42" {
+		t.Error(len(state.ChangeSet), state.Omitted, state.PageToken)
+	}
+}
+
+func TestDetectChangesMapsV3Files(t *testing.T) {
+	server := fakeChangesEndpoint(t, "42", 1)
+	defer server.Close()
+	state := NewState()
+	state.PageToken = "start"
+	withFakeEndpoint(server, func() {
+		DetectChanges(http.DefaultClient, state, "token", 100, false)
+	})
+	if len(state.ChangeSet) != 1 {
+		t.Fatal(len(state.ChangeSet))
+	}
+	f := state.ChangeSet[0].File
+	if f.Title != "file-0" || f.OwnerNames[0] != "owner" || f.Parents[0].Id != "folder" || state.ChangeSet[0].LastAction != Modified || state.ChangeSet[0].FileId != "id-file-0" {
+		t.Error(f)
+	}
+}
+
+func TestChangesInTheGracePeriodAreKeptOnlyWhenAsked(t *testing.T) {
+	server := fakeChangesEndpoint(t, "42", 1)
+	defer server.Close()
+	for _, keepRepeated := range []bool{false, true} {
+		state := NewState()
+		state.PageToken = "start"
+		state.InGracePeriod[GracePeriodKey{"file-0", "editor@example.com"}] = time.Now().Add(-time.Minute)
+		withFakeEndpoint(server, func() {
+			DetectChanges(http.DefaultClient, state, "token", 100, keepRepeated)
+		})
+		if keepRepeated && len(state.ChangeSet) != 1 {
+			t.Error(state.ChangeSet)
+		}
+		if !keepRepeated && len(state.ChangeSet) != 0 {
+			t.Error(state.ChangeSet)
+		}
+	}
+}
+
+func TestDetectChangesCountsChangesOverTheCapAsOmitted(t *testing.T) {
+	server := fakeChangesEndpoint(t, "42", 10)
+	defer server.Close()
+	state := NewState()
+	state.PageToken = "start"
+	withFakeEndpoint(server, func() {
+		if status, err := DetectChanges(http.DefaultClient, state, "token", 4, false); status != google.Ok {
+			t.Fatal(err)
+		}
+	})
+	if len(state.ChangeSet) != 4 || state.Omitted != 6 || state.PageToken != "42" {
+		t.Error(len(state.ChangeSet), state.Omitted, state.PageToken)
+	}
+}
+
+func TestFailingPageLeavesTheCursorUntouched(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/drives" {
+			writeSharedDrives(w)
+			return
+		}
+		if r.URL.Query().Get("pageToken") == "start" {
+			json.NewEncoder(w).Encode(fakeChangesPage{NextPageToken: "page-1", Changes: []map[string]interface{}{modifiedFile("a file", time.Now())}})
+			return
+		}
+		w.Write([]byte(`{"error":{"code":500,"message":"backend error"}}`))
+	}))
+	defer server.Close()
+	state := NewState()
+	state.PageToken = "start"
+	withFakeEndpoint(server, func() {
+		if status, _ := DetectChanges(http.DefaultClient, state, "token", 100, false); status != google.ApiError {
+			t.Fail()
+		}
+	})
+	if state.PageToken != "start" || len(state.InGracePeriod) != 0 {
+		t.Fail()
+	}
+}
+
+func TestStartPageTokenCreatesACursorForEverySharedDrive(t *testing.T) {
+	server := fakeChangesEndpoint(t, "42", 10, "drive")
+	defer server.Close()
+	state := NewState()
+	withFakeEndpoint(server, func() {
+		StartPageToken(http.DefaultClient, state, "token")
+	})
+	if state.PageToken != "42" || state.DrivePageTokens["drive"] != "drive-start" {
+		t.Error(state.PageToken, state.DrivePageTokens)
+	}
+}
+
+func TestDetectChangesFollowsKnownSharedDrives(t *testing.T) {
+	server := fakeChangesEndpoint(t, "42", 1, "drive")
+	defer server.Close()
+	state := NewState()
+	state.PageToken = "start"
+	state.DrivePageTokens["drive"] = "drive-start"
+	withFakeEndpoint(server, func() {
+		if status, err := DetectChanges(http.DefaultClient, state, "token", 100, false); status != google.Ok {
+			t.Fatal(err)
+		}
+	})
+	if len(state.ChangeSet) != 2 || state.ChangeSet[1].File.Title != "file-in-drive" || state.DrivePageTokens["drive"] != "drive-next" {
+		t.Error(len(state.ChangeSet), state.DrivePageTokens)
+	}
+}
+
+func TestDetectChangesOnlyCreatesACursorForNewSharedDrives(t *testing.T) {
+	server := fakeChangesEndpoint(t, "42", 1, "drive")
+	defer server.Close()
+	state := NewState()
+	state.PageToken = "start"
+	withFakeEndpoint(server, func() {
+		DetectChanges(http.DefaultClient, state, "token", 100, false)
+	})
+	if len(state.ChangeSet) != 1 || state.DrivePageTokens["drive"] != "drive-start" {
+		t.Error(len(state.ChangeSet), state.DrivePageTokens)
+	}
+}
+
+func TestV2CursorsAreMigratedToPageTokens(t *testing.T) {
+	state := NewState()
+	if err := json.Unmarshal([]byte(`{"largest_change_id":41,"in_grace_period":[]}`), state); err != nil {
+		t.Fatal(err)
+	}
+	if state.PageToken != "42" {
+		t.Fail()
+	}
+}
+
+func TestChangedFilesSurviveAJsonRoundTrip(t *testing.T) {
+	in := ChangedFile{
+		Title:      "a title",
+		OwnerNames: []string{"owner"},
+		Parents:    []Parent{{"folder"}},
+	}
+	in.ModifiedDate.Time = time.Date(2015, 1, 2, 3, 4, 5, 6000000, time.UTC)
+	b, _ := json.Marshal(in)
+	var out ChangedFile
+	if err := json.Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Title != in.Title || out.OwnerNames[0] != "owner" || out.Parents[0].Id != "folder" || !out.ModifiedDate.Equal(in.ModifiedDate.Time) || !out.SharedWithMeDate.IsZero() {
+		t.Error(string(b))
+	}
+}
@@ -0,0 +1,72 @@
+package drive
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/optionfactory/gdrive2slack/google"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+// SharedDrive is a shared drive (formerly team drive) the user is a member of
+type SharedDrive struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type drives struct {
+	NextPageToken string                `json:"nextPageToken"`
+	Items         []*SharedDrive        `json:"drives"`
+	Error         *google.ErrorResponse `json:"error"`
+}
+
+var drivesUrl = "https://www.googleapis.com/drive/v3/drives"
+
+func fetchDrivesPage(client *http.Client, accessToken string, nextPageToken string) (google.StatusCode, error, *drives) {
+	u, _ := url.Parse(drivesUrl)
+	q := u.Query()
+	q.Set("fields", "drives(id,name),nextPageToken")
+	q.Set("pageSize", "100")
+	if nextPageToken != "" {
+		q.Set("pageToken", nextPageToken)
+	}
+	u.RawQuery = q.Encode()
+	req, _ := http.NewRequest("GET", u.String(), nil)
+	req.Header.Add("Authorization", "Bearer "+accessToken)
+	response, err := client.Do(req)
+	if err != nil {
+		return google.CannotConnect, err, nil
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	var drives = new(drives)
+	err = json.Unmarshal(body, &drives)
+
+	if err != nil {
+		return google.CannotDeserialize, err, nil
+	}
+	if drives.Error != nil {
+		if drives.Error.Code == 401 {
+			return google.Unauthorized, errors.New(drives.Error.Message), nil
+		}
+		return google.ApiError, errors.New(drives.Error.Message), nil
+	}
+	return google.Ok, nil, drives
+}
+
+func FetchSharedDrives(client *http.Client, accessToken string) (google.StatusCode, error, []*SharedDrive) {
+	items := make([]*SharedDrive, 0)
+	nextPageToken := ""
+	for {
+		statusCode, err, drives := fetchDrivesPage(client, accessToken, nextPageToken)
+		if statusCode != google.Ok {
+			return statusCode, err, nil
+		}
+		items = append(items, drives.Items...)
+		if drives.NextPageToken == "" {
+			return google.Ok, nil, items
+		}
+		nextPageToken = drives.NextPageToken
+	}
+}
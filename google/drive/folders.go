@@ -15,14 +15,32 @@ type Folders struct {
 
 type folders struct {
 	NextPageToken string                `json:"nextPageToken"`
-	Items         []*folder             `json:"items"`
+	Items         []*folder             `json:"files"`
 	Error         *google.ErrorResponse `json:"error"`
 }
 
 type folder struct {
-	Id      string   `json:"id"`
-	Title   string   `json:"title"`
-	Parents []Parent `json:"parents"`
+	Id      string
+	Title   string
+	Parents []Parent
+}
+
+func (self *folder) UnmarshalJSON(b []byte) error {
+	var f struct {
+		Id      string   `json:"id"`
+		Name    string   `json:"name"`
+		Parents []string `json:"parents"`
+	}
+	if err := json.Unmarshal(b, &f); err != nil {
+		return err
+	}
+	self.Id = f.Id
+	self.Title = f.Name
+	self.Parents = make([]Parent, 0, len(f.Parents))
+	for _, parent := range f.Parents {
+		self.Parents = append(self.Parents, Parent{parent})
+	}
+	return nil
 }
 
 type Parent struct {
@@ -80,13 +98,17 @@ func index(folders []*folder) *Folders {
 	}
 }
 
+var filesUrl = "https://www.googleapis.com/drive/v3/files"
+
 func fetchFoldersPage(client *http.Client, accessToken string, nextPageToken string) (google.StatusCode, error, *folders) {
-	u, _ := url.Parse("https://www.googleapis.com/drive/v2/files")
+	u, _ := url.Parse(filesUrl)
 	q := u.Query()
-	q.Set("corpus", "DOMAIN")
+	q.Set("corpora", "allDrives")
+	q.Set("includeItemsFromAllDrives", "true")
+	q.Set("supportsAllDrives", "true")
 	q.Set("q", "mimeType = 'application/vnd.google-apps.folder'")
-	q.Set("fields", "items(id,parents(id),title),nextPageToken")
-	q.Set("maxResults", "1000")
+	q.Set("fields", "files(id,parents,name),nextPageToken")
+	q.Set("pageSize", "1000")
 	if nextPageToken != "" {
 		q.Set("pageToken", nextPageToken)
 	}
@@ -114,8 +136,21 @@ func fetchFoldersPage(client *http.Client, accessToken string, nextPageToken str
 	return google.Ok, nil, folders
 }
 
+// shared drives are indexed as root folders, so that they can be used as filters
+// and show up in the path of their folders.
 func FetchFolders(client *http.Client, accessToken string) (google.StatusCode, error, *Folders) {
-	items := make([]*folder, 0)
+	statusCode, err, sharedDrives := FetchSharedDrives(client, accessToken)
+	if statusCode != google.Ok {
+		return statusCode, err, nil
+	}
+	items := make([]*folder, 0, len(sharedDrives))
+	for _, sharedDrive := range sharedDrives {
+		items = append(items, &folder{
+			Id:      sharedDrive.Id,
+			Title:   sharedDrive.Name,
+			Parents: make([]Parent, 0),
+		})
+	}
 	nextPageToken := ""
 	for {
 		statusCode, err, folders := fetchFoldersPage(client, accessToken, nextPageToken)
@@ -130,6 +165,17 @@ func FetchFolders(client *http.Client, accessToken string) (google.StatusCode, e
 	}
 }
 
+// NewFolders indexes already resolved folders
+func NewFolders(folders ...*Folder) *Folders {
+	indexed := make(map[string]*Folder)
+	for _, folder := range folders {
+		indexed[folder.Id] = folder
+	}
+	return &Folders{
+		inner: indexed,
+	}
+}
+
 func (self *Folders) List() []*Folder {
 	list := make([]*Folder, 0)
 	for _, f := range self.inner {
@@ -146,6 +192,18 @@ func (self *Folders) PathFor(folderId string) (string, bool) {
 	return folder.Path, contained
 }
 
+// FullPathFor is the path of the folder including its own name
+func (self *Folders) FullPathFor(folderId string) (string, bool) {
+	folder, contained := self.inner[folderId]
+	if !contained {
+		return "", contained
+	}
+	if folder.Path == "" {
+		return folder.Name, contained
+	}
+	return folder.Path + "/" + folder.Name, contained
+}
+
 func (self *Folders) folderIsOrIsContainedIn(needle string, haystack string) bool {
 	current, found := self.inner[needle]
 	if !found {
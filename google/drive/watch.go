@@ -0,0 +1,112 @@
+package drive
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/optionfactory/gdrive2slack/google"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// WatchChannel is a push notification channel for the changes of a user
+type WatchChannel struct {
+	Id         string    `json:"id"`
+	ResourceId string    `json:"resource_id"`
+	Token      string    `json:"token"`
+	Expiration time.Time `json:"expiration"`
+}
+
+type channelRequest struct {
+	Id         string `json:"id"`
+	ResourceId string `json:"resourceId,omitempty"`
+	Type       string `json:"type,omitempty"`
+	Address    string `json:"address,omitempty"`
+	Token      string `json:"token,omitempty"`
+	Expiration int64  `json:"expiration,string,omitempty"`
+}
+
+type channelResponse struct {
+	Id         string                `json:"id"`
+	ResourceId string                `json:"resourceId"`
+	Token      string                `json:"token"`
+	Expiration string                `json:"expiration"`
+	Error      *google.ErrorResponse `json:"error"`
+}
+
+var (
+	watchUrl       = "https://www.googleapis.com/drive/v3/changes/watch"
+	stopChannelUrl = "https://www.googleapis.com/drive/v3/channels/stop"
+)
+
+func postChannelRequest(client *http.Client, accessToken string, u string, request *channelRequest) (google.StatusCode, error, *channelResponse) {
+	payload, _ := json.Marshal(request)
+	req, _ := http.NewRequest("POST", u, bytes.NewBuffer(payload))
+	req.Header.Add("Authorization", "Bearer "+accessToken)
+	req.Header.Add("Content-Type", "application/json")
+	response, err := client.Do(req)
+	if err != nil {
+		return google.CannotConnect, err, nil
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	var channel = new(channelResponse)
+	if len(body) == 0 && response.StatusCode < 300 {
+		return google.Ok, nil, channel
+	}
+	err = json.Unmarshal(body, &channel)
+
+	if err != nil {
+		return google.CannotDeserialize, err, nil
+	}
+	if channel.Error != nil {
+		if channel.Error.Code == 401 {
+			return google.Unauthorized, errors.New(channel.Error.Message), nil
+		}
+		return google.ApiError, errors.New(channel.Error.Message), nil
+	}
+	return google.Ok, nil, channel
+}
+
+// Watch registers a channel delivering a notification to address every time
+// the changes starting from state.PageToken (in any drive) are updated.
+func Watch(client *http.Client, state *State, accessToken string, address string, id string, token string, ttl time.Duration) (google.StatusCode, error, *WatchChannel) {
+	u, _ := url.Parse(watchUrl)
+	q := u.Query()
+	q.Set("pageToken", state.PageToken)
+	q.Set("includeRemoved", "true")
+	q.Set("supportsAllDrives", "true")
+	q.Set("includeItemsFromAllDrives", "true")
+	u.RawQuery = q.Encode()
+	statusCode, err, channel := postChannelRequest(client, accessToken, u.String(), &channelRequest{
+		Id:         id,
+		Type:       "web_hook",
+		Address:    address,
+		Token:      token,
+		Expiration: time.Now().Add(ttl).UnixNano() / int64(time.Millisecond),
+	})
+	if statusCode != google.Ok {
+		return statusCode, err, nil
+	}
+	expiration, err := strconv.ParseInt(channel.Expiration, 10, 64)
+	if err != nil {
+		return google.CannotDeserialize, err, nil
+	}
+	return google.Ok, nil, &WatchChannel{
+		Id:         channel.Id,
+		ResourceId: channel.ResourceId,
+		Token:      token,
+		Expiration: time.Unix(0, expiration*int64(time.Millisecond)),
+	}
+}
+
+func StopWatching(client *http.Client, accessToken string, channel *WatchChannel) (google.StatusCode, error) {
+	statusCode, err, _ := postChannelRequest(client, accessToken, stopChannelUrl, &channelRequest{
+		Id:         channel.Id,
+		ResourceId: channel.ResourceId,
+	})
+	return statusCode, err
+}
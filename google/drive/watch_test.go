@@ -0,0 +1,38 @@
+package drive
+
+import (
+	"encoding/json"
+	"github.com/optionfactory/gdrive2slack/google"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWatchRegistersAWebHookFromTheCurrentPageToken(t *testing.T) {
+	var got channelRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("pageToken") != "42" {
+			t.Error("unexpected page token", r.URL.Query().Get("pageToken"))
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"id":"an-id","resourceId":"a-resource","expiration":"1420070400000"}`))
+	}))
+	defer server.Close()
+	defer func(original string) {
+		watchUrl = original
+	}(watchUrl)
+	watchUrl = server.URL
+	state := NewState()
+	state.PageToken = "42"
+	status, err, channel := Watch(http.DefaultClient, state, "token", "https://example.com/hook", "an-id", "a-token", time.Hour)
+	if status != google.Ok {
+		t.Fatal(err)
+	}
+	if got.Type != "web_hook" || got.Address != "https://example.com/hook" || got.Token != "a-token" {
+		t.Error(got)
+	}
+	if channel.ResourceId != "a-resource" || channel.Token != "a-token" || !channel.Expiration.Equal(time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Error(channel)
+	}
+}
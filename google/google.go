@@ -43,6 +43,10 @@ func (t *Timestamp) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
+func (t Timestamp) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + t.Time.UTC().Format("2006-01-02T15:04:05.000Z") + `"`), nil
+}
+
 func (t *Timestamp) Gte(others ...Timestamp) bool {
 	for _, other := range others {
 		if !t.Time.Equal(other.Time) && !t.Time.After(other.Time) {
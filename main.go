@@ -24,4 +24,5 @@ func main() {
 
 	go gdrive2slack.EventLoop(env)
 	gdrive2slack.ServeHttp(env)
+	logger.Info("Stopped")
 }
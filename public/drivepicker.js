@@ -20,8 +20,8 @@
             if(this.rootFolderId) {
                 self._pick(lock);
             } else if(this.oauthToken && this.driveLoaded) {
-                gapi.client.drive.about.get().execute(function(resp) {
-                    self.rootFolderId = resp.rootFolderId;
+                gapi.client.drive.files.get({fileId: 'root', fields: 'id'}).execute(function(resp) {
+                    self.rootFolderId = resp.id;
                     self._pick(lock);
                 })
             }
@@ -36,7 +36,7 @@
             var self = this;
             var lock = {};
             
-            gapi.client.load("drive", "v2", function() {
+            gapi.client.load("drive", "v3", function() {
                 self.driveLoaded = true;
                 self._loadRootFolder(lock);
             });
@@ -68,8 +68,15 @@
             view.setIncludeFolders(true);
             view.setSelectFolderEnabled(true);
             view.setParent(this.rootFolderId);
+            var sharedDrives = new google.picker.DocsView();
+            sharedDrives.setMimeTypes("application/vnd.google-apps.folder");
+            sharedDrives.setIncludeFolders(true);
+            sharedDrives.setSelectFolderEnabled(true);
+            sharedDrives.setEnableDrives(true);
             var picker = new google.picker.PickerBuilder().
+                enableFeature(google.picker.Feature.SUPPORT_DRIVES).
                 addView(view).
+                addView(sharedDrives).
                 setOAuthToken(this.oauthToken).
                 setDeveloperKey(this.apiKey).
                 setCallback(this.pickerCallback.bind(this)).
@@ -3,8 +3,11 @@ package slack
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
+	"time"
 )
 
 type StatusCode int
@@ -23,6 +26,10 @@ const (
 	TokenRevoked
 	AccountInactive
 	UserIsBot
+	UsersNotFound
+	MissingScope
+	MessageNotFound
+	CantUpdateMessage
 	UnknownError
 )
 
@@ -42,16 +49,21 @@ func NewStatusCodeFromError(ec string) StatusCode {
 }
 
 var errorLabelToStatusCode = map[string]StatusCode{
-	"channel_not_found": ChannelNotFound,
-	"is_archived":       IsArchived,
-	"msg_too_long":      MsgTooLong,
-	"no_text":           NoText,
-	"rate_limited":      RateLimited,
-	"not_authed":        NotAuthed,
-	"invalid_auth":      InvalidAuth,
-	"token_revoked":     TokenRevoked,
-	"account_inactive":  AccountInactive,
-	"user_is_bot":       UserIsBot,
+	"channel_not_found":   ChannelNotFound,
+	"is_archived":         IsArchived,
+	"msg_too_long":        MsgTooLong,
+	"no_text":             NoText,
+	"rate_limited":        RateLimited,
+	"ratelimited":         RateLimited,
+	"not_authed":          NotAuthed,
+	"invalid_auth":        InvalidAuth,
+	"token_revoked":       TokenRevoked,
+	"account_inactive":    AccountInactive,
+	"user_is_bot":         UserIsBot,
+	"users_not_found":     UsersNotFound,
+	"missing_scope":       MissingScope,
+	"message_not_found":   MessageNotFound,
+	"cant_update_message": CantUpdateMessage,
 }
 
 var statusCodes = []string{
@@ -68,11 +80,16 @@ var statusCodes = []string{
 	TokenRevoked:      "token_revoked",
 	AccountInactive:   "account_inactive",
 	UserIsBot:         "user_is_bot",
+	UsersNotFound:     "users_not_found",
+	MissingScope:      "missing_scope",
+	MessageNotFound:   "message_not_found",
+	CantUpdateMessage: "cant_update_message",
 	UnknownError:      "unknown_error",
 }
 
 type Attachment struct {
 	Fallback string  `json:"fallback"`
+	Title    string  `json:"title,omitempty"`
 	Color    string  `json:"color"`
 	Fields   []Field `json:"fields"`
 }
@@ -88,12 +105,23 @@ type Message struct {
 	Username    string       `json:"username"`
 	Text        string       `json:"text"`
 	Attachments []Attachment `json:"attachments"`
+	Blocks      []Block      `json:"blocks,omitempty"`
 	IconUrl     string       `json:"icon_url"`
+	ThreadTs    string       `json:"thread_ts,omitempty"`
+	// ReplyBroadcast shows a thread reply in the channel as well
+	ReplyBroadcast bool `json:"reply_broadcast,omitempty"`
+}
+
+// PostedMessage identifies a message for chat.update, Channel being the channel id
+type PostedMessage struct {
+	Channel string `json:"channel"`
+	Ts      string `json:"ts"`
 }
 
 type PostMessageResponse struct {
 	Ok    bool   `json:"ok"`
 	Error string `json:"error"`
+	PostedMessage
 }
 
 type UserInfo struct {
@@ -129,27 +157,86 @@ func GetUserInfo(client *http.Client, accessToken string) (*UserInfo, StatusCode
 	return self.UserInfo, Ok, nil
 }
 
-func PostMessage(client *http.Client, accessToken string, message *Message) (StatusCode, error) {
+// RateLimitError is returned along with the RateLimited status code
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (self *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limited, retry after %v", self.RetryAfter)
+}
+
+const defaultRetryAfter = time.Minute
+
+func retryAfter(response *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(response.Header.Get("Retry-After"))
+	if err != nil || seconds <= 0 {
+		return defaultRetryAfter
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func messageForm(accessToken string, message *Message) url.Values {
 	payload, _ := json.Marshal(message.Attachments)
-	response, err := client.PostForm("https://slack.com/api/chat.postMessage", url.Values{
+	form := url.Values{
 		"token":       {accessToken},
 		"channel":     {message.Channel},
 		"username":    {message.Username},
 		"text":        {message.Text},
 		"icon_url":    {message.IconUrl},
 		"attachments": {string(payload)},
-	})
+	}
+	if len(message.Blocks) != 0 {
+		blocks, _ := json.Marshal(message.Blocks)
+		form.Set("blocks", string(blocks))
+	}
+	if message.ThreadTs != "" {
+		form.Set("thread_ts", message.ThreadTs)
+	}
+	if message.ReplyBroadcast {
+		form.Set("reply_broadcast", "true")
+	}
+	return form
+}
+
+func postMessageForm(client *http.Client, u string, form url.Values) (*PostedMessage, StatusCode, error) {
+	response, err := client.PostForm(u, form)
 	if err != nil {
-		return CannotConnect, err
+		return nil, CannotConnect, err
 	}
 	defer response.Body.Close()
+	if response.StatusCode == 429 {
+		return nil, RateLimited, &RateLimitError{retryAfter(response)}
+	}
 	var self = new(PostMessageResponse)
 	err = json.NewDecoder(response.Body).Decode(self)
 	if err != nil {
-		return CannotDeserialize, err
+		return nil, CannotDeserialize, err
 	}
 	if !self.Ok {
-		return NewStatusCodeFromError(self.Error), errors.New(self.Error)
+		status := NewStatusCodeFromError(self.Error)
+		if status == RateLimited {
+			return nil, status, &RateLimitError{retryAfter(response)}
+		}
+		return nil, status, errors.New(self.Error)
 	}
-	return Ok, nil
+	return &self.PostedMessage, Ok, nil
+}
+
+// PostMessage yields the channel id and the timestamp identifying the posted message
+func PostMessage(client *http.Client, accessToken string, message *Message) (*PostedMessage, StatusCode, error) {
+	return postMessageForm(client, "https://slack.com/api/chat.postMessage", messageForm(accessToken, message))
+}
+
+// UpdateMessage replaces the content of a posted message, the channel of the
+// message is ignored in favour of the one of target
+func UpdateMessage(client *http.Client, accessToken string, target *PostedMessage, message *Message) (*PostedMessage, StatusCode, error) {
+	form := messageForm(accessToken, message)
+	form.Set("channel", target.Channel)
+	form.Set("ts", target.Ts)
+	form.Del("username")
+	form.Del("icon_url")
+	form.Del("thread_ts")
+	form.Del("reply_broadcast")
+	return postMessageForm(client, "https://slack.com/api/chat.update", form)
 }
@@ -0,0 +1,89 @@
+package slack
+
+// Block is a Block Kit layout block, only section, context, actions and
+// divider blocks are supported. Elements are text objects in context blocks
+// and buttons in actions blocks.
+type Block struct {
+	Type     string        `json:"type"`
+	Text     *TextObject   `json:"text,omitempty"`
+	Fields   []*TextObject `json:"fields,omitempty"`
+	Elements []interface{} `json:"elements,omitempty"`
+}
+
+type TextObject struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// Button sends the value to the interactivity endpoint of the app when clicked
+type Button struct {
+	Type     string      `json:"type"`
+	Text     *TextObject `json:"text"`
+	ActionId string      `json:"action_id"`
+	Value    string      `json:"value"`
+}
+
+// MaxBlocks is the maximum number of blocks slack accepts in a message
+const MaxBlocks = 50
+
+func Markdown(text string) *TextObject {
+	return &TextObject{
+		Type: "mrkdwn",
+		Text: text,
+	}
+}
+
+func PlainText(text string) *TextObject {
+	return &TextObject{
+		Type: "plain_text",
+		Text: text,
+	}
+}
+
+func NewButton(actionId string, value string, text string) *Button {
+	return &Button{
+		Type:     "button",
+		Text:     PlainText(text),
+		ActionId: actionId,
+		Value:    value,
+	}
+}
+
+func NewSectionBlock(text string, fields ...string) Block {
+	block := Block{
+		Type: "section",
+		Text: Markdown(text),
+	}
+	for _, field := range fields {
+		block.Fields = append(block.Fields, Markdown(field))
+	}
+	return block
+}
+
+func NewContextBlock(elements ...string) Block {
+	block := Block{
+		Type:     "context",
+		Elements: make([]interface{}, 0, len(elements)),
+	}
+	for _, element := range elements {
+		block.Elements = append(block.Elements, Markdown(element))
+	}
+	return block
+}
+
+func NewActionsBlock(buttons ...*Button) Block {
+	block := Block{
+		Type:     "actions",
+		Elements: make([]interface{}, 0, len(buttons)),
+	}
+	for _, button := range buttons {
+		block.Elements = append(block.Elements, button)
+	}
+	return block
+}
+
+func NewDividerBlock() Block {
+	return Block{
+		Type: "divider",
+	}
+}
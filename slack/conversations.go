@@ -0,0 +1,98 @@
+package slack
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+// Conversation is a public or private channel, Name comes without the leading #
+type Conversation struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type conversationsListResponse struct {
+	Ok               bool            `json:"ok"`
+	Error            string          `json:"error"`
+	Channels         []*Conversation `json:"channels"`
+	ResponseMetadata struct {
+		NextCursor string `json:"next_cursor"`
+	} `json:"response_metadata"`
+}
+
+type conversationInfoResponse struct {
+	Ok      bool          `json:"ok"`
+	Error   string        `json:"error"`
+	Channel *Conversation `json:"channel"`
+}
+
+// IsConversationId tells ids of public channels, private channels and direct
+// messages apart from channel names
+func IsConversationId(channel string) bool {
+	if len(channel) < 2 || (channel[0] != 'C' && channel[0] != 'G' && channel[0] != 'D') {
+		return false
+	}
+	for _, c := range channel[1:] {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// ListConversations yields the channels which are not archived, it requires
+// the channels:read and groups:read scopes
+func ListConversations(client *http.Client, accessToken string) ([]*Conversation, StatusCode, error) {
+	conversations := make([]*Conversation, 0)
+	cursor := ""
+	for {
+		response, err := client.PostForm("https://slack.com/api/conversations.list", url.Values{
+			"token":            {accessToken},
+			"types":            {"public_channel,private_channel"},
+			"exclude_archived": {"true"},
+			"limit":            {"1000"},
+			"cursor":           {cursor},
+		})
+		if err != nil {
+			return nil, CannotConnect, err
+		}
+		var self = new(conversationsListResponse)
+		err = json.NewDecoder(response.Body).Decode(self)
+		response.Body.Close()
+		if err != nil {
+			return nil, CannotDeserialize, err
+		}
+		if !self.Ok {
+			return nil, NewStatusCodeFromError(self.Error), errors.New(self.Error)
+		}
+		conversations = append(conversations, self.Channels...)
+		cursor = self.ResponseMetadata.NextCursor
+		if cursor == "" {
+			return conversations, Ok, nil
+		}
+	}
+}
+
+// GetConversationInfo yields the current name of a channel, it requires the
+// channels:read and groups:read scopes
+func GetConversationInfo(client *http.Client, accessToken string, id string) (*Conversation, StatusCode, error) {
+	response, err := client.PostForm("https://slack.com/api/conversations.info", url.Values{
+		"token":   {accessToken},
+		"channel": {id},
+	})
+	if err != nil {
+		return nil, CannotConnect, err
+	}
+	defer response.Body.Close()
+	var self = new(conversationInfoResponse)
+	err = json.NewDecoder(response.Body).Decode(self)
+	if err != nil {
+		return nil, CannotDeserialize, err
+	}
+	if !self.Ok {
+		return nil, NewStatusCodeFromError(self.Error), errors.New(self.Error)
+	}
+	return self.Channel, Ok, nil
+}
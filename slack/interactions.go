@@ -0,0 +1,52 @@
+package slack
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+type InteractionTeam struct {
+	Id string `json:"id"`
+}
+
+type InteractionUser struct {
+	Id       string `json:"id"`
+	Username string `json:"username"`
+}
+
+type Action struct {
+	ActionId string `json:"action_id"`
+	Value    string `json:"value"`
+}
+
+// Interaction is the payload slack posts when a user clicks a button
+type Interaction struct {
+	Type        string          `json:"type"`
+	Team        InteractionTeam `json:"team"`
+	User        InteractionUser `json:"user"`
+	Actions     []Action        `json:"actions"`
+	ResponseUrl string          `json:"response_url"`
+}
+
+type actionResponse struct {
+	ResponseType    string `json:"response_type"`
+	ReplaceOriginal bool   `json:"replace_original"`
+	Text            string `json:"text"`
+}
+
+// Respond shows text only to the user who clicked a button or ran a slash
+// command, leaving the message untouched
+func Respond(client *http.Client, responseUrl string, text string) (StatusCode, error) {
+	payload, _ := json.Marshal(&actionResponse{"ephemeral", false, text})
+	response, err := client.Post(responseUrl, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return CannotConnect, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != 200 {
+		return UnknownError, errors.New(response.Status)
+	}
+	return Ok, nil
+}
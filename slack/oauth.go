@@ -57,6 +57,8 @@ type OauthConfiguration struct {
 	ClientId     string `json:"client_id"`
 	ClientSecret string `json:"client_secret"`
 	RedirectUri  string `json:"redirect_uri"`
+	// SigningSecret verifies the requests sent by slack, such as slash commands
+	SigningSecret string `json:"signing_secret"`
 }
 
 type OauthTokenResponse struct {
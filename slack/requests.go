@@ -0,0 +1,38 @@
+package slack
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// requests older than this are rejected to prevent replays
+const maxRequestAge = 5 * time.Minute
+
+// RequestSignature is the value slack sends in the X-Slack-Signature header
+func RequestSignature(signingSecret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(signingSecret))
+	fmt.Fprintf(mac, "v0:%s:", timestamp)
+	mac.Write(body)
+	return "v0=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyRequest tells whether the request with the given body was signed by
+// slack with the signing secret of the app
+func VerifyRequest(signingSecret string, header http.Header, body []byte, now time.Time) bool {
+	timestamp := header.Get("X-Slack-Request-Timestamp")
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	age := now.Sub(time.Unix(seconds, 0))
+	if age > maxRequestAge || age < -maxRequestAge {
+		return false
+	}
+	expected := RequestSignature(signingSecret, timestamp, body)
+	return hmac.Equal([]byte(expected), []byte(header.Get("X-Slack-Signature")))
+}
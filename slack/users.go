@@ -0,0 +1,59 @@
+package slack
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+type Profile struct {
+	DisplayName string `json:"display_name"`
+	RealName    string `json:"real_name"`
+}
+
+// User is a member of a slack team
+type User struct {
+	Id       string  `json:"id"`
+	Name     string  `json:"name"`
+	RealName string  `json:"real_name"`
+	Profile  Profile `json:"profile"`
+}
+
+// VisibleName is the name slack shows for the user
+func (self *User) VisibleName() string {
+	if self.Profile.DisplayName != "" {
+		return self.Profile.DisplayName
+	}
+	if self.RealName != "" {
+		return self.RealName
+	}
+	return self.Name
+}
+
+type lookupByEmailResponse struct {
+	Ok    bool   `json:"ok"`
+	Error string `json:"error"`
+	User  *User  `json:"user"`
+}
+
+// LookupUserByEmail requires the users:read.email scope
+func LookupUserByEmail(client *http.Client, accessToken string, email string) (*User, StatusCode, error) {
+	response, err := client.PostForm("https://slack.com/api/users.lookupByEmail", url.Values{
+		"token": {accessToken},
+		"email": {email},
+	})
+	if err != nil {
+		return nil, CannotConnect, err
+	}
+	defer response.Body.Close()
+	var self = new(lookupByEmailResponse)
+	err = json.NewDecoder(response.Body).Decode(self)
+	if err != nil {
+		return nil, CannotDeserialize, err
+	}
+	if !self.Ok {
+		return nil, NewStatusCodeFromError(self.Error), errors.New(self.Error)
+	}
+	return self.User, Ok, nil
+}